@@ -0,0 +1,69 @@
+// Command reindex-search rebuilds the search index items for every entry in
+// the table. Run it to backfill entries logged before search existed, or
+// after the index's attributes change; writes through the dynamo package
+// keep the index in sync after that.
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/BrianLeishman/justlog.io/go/config"
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func main() {
+	if _, err := config.Load(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	db, err := dynamo.Client()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var indexed int
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := db.Scan(ctx, &dynamodb.ScanInput{
+			TableName:         aws.String(dynamo.TableName()),
+			FilterExpression:  aws.String("begins_with(sk, :food) OR begins_with(sk, :exercise) OR begins_with(sk, :weight)"),
+			ExclusiveStartKey: startKey,
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":food":     &types.AttributeValueMemberS{Value: "food#"},
+				":exercise": &types.AttributeValueMemberS{Value: "exercise#"},
+				":weight":   &types.AttributeValueMemberS{Value: "weight#"},
+			},
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, item := range out.Items {
+			var e dynamo.Entry
+			if err := attributevalue.UnmarshalMap(item, &e); err != nil {
+				log.Printf("skip item: %v", err)
+				continue
+			}
+			if e.Type == "" {
+				e.Type = e.SK[:strings.Index(e.SK, "#")]
+			}
+			if err := dynamo.PutSearchIndex(ctx, e); err != nil {
+				log.Fatal(err)
+			}
+			indexed++
+		}
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	log.Printf("indexed %d entries", indexed)
+}
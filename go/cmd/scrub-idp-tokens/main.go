@@ -0,0 +1,71 @@
+// Command scrub-idp-tokens removes the Cognito access and refresh tokens
+// that OAuth sessions and authorization codes used to store in plaintext.
+// Nothing writes them anymore, so run it once to clean up items TTL hasn't
+// deleted yet.
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/BrianLeishman/justlog.io/go/config"
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func main() {
+	if _, err := config.Load(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	db, err := dynamo.Client()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var scrubbed int
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := db.Scan(ctx, &dynamodb.ScanInput{
+			TableName:            aws.String(dynamo.TableName()),
+			FilterExpression:     aws.String("attribute_exists(CognitoAccessToken) OR attribute_exists(CognitoRefreshToken)"),
+			ProjectionExpression: aws.String("uid, sk"),
+			ExclusiveStartKey:    startKey,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, item := range out.Items {
+			_, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName: aws.String(dynamo.TableName()),
+				Key: map[string]types.AttributeValue{
+					"uid": item["uid"],
+					"sk":  item["sk"],
+				},
+				UpdateExpression: aws.String("REMOVE CognitoAccessToken, CognitoRefreshToken"),
+				// Don't recreate items that expired or were redeemed
+				// since the scan
+				ConditionExpression: aws.String("attribute_exists(uid)"),
+			})
+			var failed *types.ConditionalCheckFailedException
+			if errors.As(err, &failed) {
+				continue
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			scrubbed++
+		}
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	log.Printf("scrubbed %d items", scrubbed)
+}
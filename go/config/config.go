@@ -0,0 +1,334 @@
+// Package config holds the deployment settings shared by the Lambdas, the
+// local servers and the commands: public URLs, the Cognito app client, the
+// DynamoDB table, the auth cache, rate limits and audit retention.
+// Defaults are the production values, so a bare checkout still talks to the
+// real deployment; a JSON file named by JUSTLOG_CONFIG and then environment
+// variables override them.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// BaseURL is the public URL of the MCP server and OAuth issuer.
+	BaseURL string `json:"base_url"`
+
+	// CognitoDomain is the hosted UI domain users sign in through.
+	CognitoDomain string `json:"cognito_domain"`
+	// CognitoClientID is the app client the OAuth flow signs users in with.
+	CognitoClientID string `json:"cognito_client_id"`
+	// CognitoIssuer is the user pool URL. When set, Cognito tokens are
+	// verified locally against its JWKS instead of calling userInfo.
+	CognitoIssuer string `json:"cognito_issuer"`
+	// CognitoJWKSURL defaults to the issuer's /.well-known/jwks.json.
+	CognitoJWKSURL string `json:"cognito_jwks_url"`
+	// CognitoClientIDs are the app clients whose tokens are accepted.
+	// Defaults to CognitoClientID.
+	CognitoClientIDs []string `json:"cognito_client_ids"`
+
+	// IdentityProviders are OpenID Connect providers users can sign in
+	// with alongside Cognito. Set cognito_domain to "" to offer only these.
+	IdentityProviders []IdentityProvider `json:"identity_providers"`
+
+	// TableName is the DynamoDB table everything lives in.
+	TableName string `json:"table_name"`
+	// DynamoEndpoint overrides the DynamoDB endpoint, e.g.
+	// http://localhost:8000 for DynamoDB Local.
+	DynamoEndpoint string `json:"dynamo_endpoint"`
+
+	// AuthCacheTTL bounds how long token lookups are reused. 0 disables
+	// caching.
+	AuthCacheTTL Duration `json:"auth_cache_ttl"`
+
+	// RegistrationsPerHour caps OAuth client registrations per IP address.
+	RegistrationsPerHour int `json:"registrations_per_hour"`
+	// UnusedClientTTL is how long a registered OAuth client lives if it
+	// never completes an authorization.
+	UnusedClientTTL Duration `json:"unused_client_ttl"`
+
+	// CredentialRequestsPerMinute and UserRequestsPerMinute limit API
+	// requests and MCP tool calls per API key or token and per user. Each
+	// is a token bucket holding a minute's worth of requests. 0 disables
+	// the limit.
+	CredentialRequestsPerMinute int `json:"credential_requests_per_minute"`
+	UserRequestsPerMinute       int `json:"user_requests_per_minute"`
+	// RateLimitStore is where the buckets are kept: "dynamodb", shared by
+	// every instance, or "memory" for local runs.
+	RateLimitStore string `json:"rate_limit_store"`
+
+	// AuditRetention is how long audit events are kept.
+	AuditRetention Duration `json:"audit_retention"`
+
+	// DebugUsers are user IDs whose requests are logged at debug level,
+	// including what they send and get back. Secrets are still redacted.
+	DebugUsers []string `json:"debug_users"`
+
+	// OpenAIChallenge is served at /.well-known/openai-apps-challenge for
+	// OpenAI domain verification. Empty disables the route.
+	OpenAIChallenge string `json:"openai_challenge"`
+
+	// APIAddr and MCPAddr are where the local servers listen.
+	APIAddr string `json:"api_addr"`
+	MCPAddr string `json:"mcp_addr"`
+}
+
+// IdentityProvider is an OpenID Connect provider found through discovery at
+// Issuer + /.well-known/openid-configuration.
+type IdentityProvider struct {
+	// Name identifies the provider in URLs and in stored identities, so it
+	// shouldn't change once users have signed in with it.
+	Name string `json:"name"`
+	// Title is shown on the sign-in chooser. Defaults to Name.
+	Title        string `json:"title"`
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// Scopes default to openid email profile.
+	Scopes []string `json:"scopes"`
+}
+
+// Duration is a time.Duration that reads as a Go duration string in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"1m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func defaults() Config {
+	return Config{
+		BaseURL:         "https://k24xsd279c.execute-api.us-east-1.amazonaws.com",
+		CognitoDomain:   "https://justlog.auth.us-east-1.amazoncognito.com",
+		CognitoClientID: "11h4ggbj2m9hehirq0n7hcq5m8",
+		TableName:       "justlog",
+		AuthCacheTTL:    Duration(time.Minute),
+
+		RegistrationsPerHour: 10,
+		UnusedClientTTL:      Duration(24 * time.Hour),
+
+		CredentialRequestsPerMinute: 60,
+		UserRequestsPerMinute:       120,
+		RateLimitStore:              "dynamodb",
+
+		AuditRetention: Duration(90 * 24 * time.Hour),
+
+		OpenAIChallenge: "RiotatjG6D-VQ-7RnzdYBxIeWm8ZKSYTlDjxxIJupT4",
+		APIAddr:         ":8080",
+		MCPAddr:         ":8088",
+	}
+}
+
+var load = sync.OnceValues(func() (*Config, error) {
+	c := defaults()
+	if path := os.Getenv("JUSTLOG_CONFIG"); path != "" {
+		if err := c.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.readEnv(); err != nil {
+		return nil, err
+	}
+	if len(c.CognitoClientIDs) == 0 {
+		c.CognitoClientIDs = []string{c.CognitoClientID}
+	}
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	c.CognitoDomain = strings.TrimSuffix(c.CognitoDomain, "/")
+	for i := range c.IdentityProviders {
+		p := &c.IdentityProviders[i]
+		p.Issuer = strings.TrimSuffix(p.Issuer, "/")
+		if p.Title == "" {
+			p.Title = p.Name
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+})
+
+// Load reads and validates the configuration. It only does the work once;
+// call it at startup so a bad setting stops the process before it serves
+// anything.
+func Load() (*Config, error) {
+	return load()
+}
+
+// Get returns the configuration, panicking if it's invalid. Entry points
+// call Load first, so in practice this never panics.
+func Get() *Config {
+	c, err := load()
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) readEnv() error {
+	for name, dst := range map[string]*string{
+		"JUSTLOG_BASE_URL":      &c.BaseURL,
+		"COGNITO_DOMAIN":        &c.CognitoDomain,
+		"COGNITO_CLIENT_ID":     &c.CognitoClientID,
+		"COGNITO_ISSUER":        &c.CognitoIssuer,
+		"COGNITO_JWKS_URL":      &c.CognitoJWKSURL,
+		"DYNAMODB_TABLE":        &c.TableName,
+		"DYNAMODB_ENDPOINT":     &c.DynamoEndpoint,
+		"OPENAI_APPS_CHALLENGE": &c.OpenAIChallenge,
+		"API_ADDR":              &c.APIAddr,
+		"MCP_ADDR":              &c.MCPAddr,
+		"RATE_LIMIT_STORE":      &c.RateLimitStore,
+	} {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	if v := os.Getenv("IDENTITY_PROVIDERS"); v != "" {
+		c.IdentityProviders = nil
+		if err := json.Unmarshal([]byte(v), &c.IdentityProviders); err != nil {
+			return fmt.Errorf("config: IDENTITY_PROVIDERS: %w", err)
+		}
+	}
+	if v := os.Getenv("COGNITO_CLIENT_IDS"); v != "" {
+		c.CognitoClientIDs = strings.Split(v, ",")
+	}
+	if v := os.Getenv("DEBUG_USERS"); v != "" {
+		c.DebugUsers = strings.Split(v, ",")
+	}
+	for name, dst := range map[string]*Duration{
+		"AUTH_CACHE_TTL":    &c.AuthCacheTTL,
+		"UNUSED_CLIENT_TTL": &c.UnusedClientTTL,
+		"AUDIT_RETENTION":   &c.AuditRetention,
+	} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("config: %s: %w", name, err)
+			}
+			*dst = Duration(d)
+		}
+	}
+	for name, dst := range map[string]*int{
+		"REGISTRATIONS_PER_HOUR":         &c.RegistrationsPerHour,
+		"CREDENTIAL_REQUESTS_PER_MINUTE": &c.CredentialRequestsPerMinute,
+		"USER_REQUESTS_PER_MINUTE":       &c.UserRequestsPerMinute,
+	} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("config: %s: %w", name, err)
+			}
+			*dst = n
+		}
+	}
+	return nil
+}
+
+// Validate checks that required settings are present and well formed.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(name, value string, required bool) {
+		if value == "" {
+			if required {
+				errs = append(errs, fmt.Errorf("%s is required", name))
+			}
+			return
+		}
+		u, err := url.Parse(value)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			errs = append(errs, fmt.Errorf("%s must be an absolute http(s) URL, got %q", name, value))
+		}
+	}
+	check("base_url", c.BaseURL, true)
+	check("cognito_domain", c.CognitoDomain, false)
+	check("cognito_issuer", c.CognitoIssuer, false)
+	check("cognito_jwks_url", c.CognitoJWKSURL, false)
+	check("dynamo_endpoint", c.DynamoEndpoint, false)
+
+	if c.CognitoDomain != "" && c.CognitoClientID == "" {
+		errs = append(errs, errors.New("cognito_client_id is required"))
+	}
+	if c.CognitoDomain == "" && len(c.IdentityProviders) == 0 {
+		errs = append(errs, errors.New("cognito_domain or at least one identity provider is required"))
+	}
+	names := map[string]bool{}
+	for i, p := range c.IdentityProviders {
+		switch {
+		case p.Name == "" || strings.Trim(p.Name, "abcdefghijklmnopqrstuvwxyz0123456789-") != "":
+			errs = append(errs, fmt.Errorf("identity_providers[%d]: name must be lowercase letters, digits and dashes", i))
+		case names[p.Name] || p.Name == "cognito":
+			errs = append(errs, fmt.Errorf("identity_providers[%d]: name %q is already used", i, p.Name))
+		}
+		names[p.Name] = true
+		check(fmt.Sprintf("identity_providers[%d].issuer", i), p.Issuer, true)
+		if p.ClientID == "" {
+			errs = append(errs, fmt.Errorf("identity_providers[%d]: client_id is required", i))
+		}
+	}
+	for _, id := range c.CognitoClientIDs {
+		if strings.TrimSpace(id) == "" {
+			errs = append(errs, errors.New("cognito_client_ids has an empty entry"))
+			break
+		}
+	}
+	if c.TableName == "" {
+		errs = append(errs, errors.New("table_name is required"))
+	}
+	if c.AuthCacheTTL < 0 {
+		errs = append(errs, errors.New("auth_cache_ttl can't be negative"))
+	}
+	if c.RegistrationsPerHour < 1 {
+		errs = append(errs, errors.New("registrations_per_hour must be at least 1"))
+	}
+	if c.UnusedClientTTL < Duration(time.Hour) {
+		errs = append(errs, errors.New("unused_client_ttl must be at least 1h"))
+	}
+	if c.AuditRetention < Duration(24*time.Hour) {
+		errs = append(errs, errors.New("audit_retention must be at least 24h"))
+	}
+	if c.CredentialRequestsPerMinute < 0 || c.UserRequestsPerMinute < 0 {
+		errs = append(errs, errors.New("credential_requests_per_minute and user_requests_per_minute can't be negative"))
+	}
+	if c.RateLimitStore != "dynamodb" && c.RateLimitStore != "memory" {
+		errs = append(errs, fmt.Errorf("rate_limit_store must be dynamodb or memory, got %q", c.RateLimitStore))
+	}
+	if c.APIAddr == "" || c.MCPAddr == "" {
+		errs = append(errs, errors.New("api_addr and mcp_addr are required"))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	return nil
+}
@@ -5,7 +5,9 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -30,66 +32,221 @@ func randomHex(n int) (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// APIKeyTouchInterval is how stale a key's or OAuth grant's LastUsedAt has to
+// be before a lookup writes a new one, so busy credentials don't cost a
+// write per request.
+const APIKeyTouchInterval = 5 * time.Minute
+
+// ErrKeyNotRotatable means an API key has already been rotated or has
+// expired, so it can't be rotated again.
+var ErrKeyNotRotatable = errors.New("api key already rotated or expired")
+
+// APIKeyRotationGrace is how long a rotated key keeps working by default, so
+// integrations can be switched over to its replacement.
+const APIKeyRotationGrace = 24 * time.Hour
+
 // APIKeyInfo holds metadata about an API key (never the raw key).
 type APIKeyInfo struct {
-	KeyID     string `json:"key_id"`
-	Label     string `json:"label"`
-	CreatedAt string `json:"created_at"`
+	KeyID      string   `json:"key_id"`
+	Label      string   `json:"label"`
+	CreatedAt  string   `json:"created_at"`
+	Scopes     []string `json:"scopes,omitempty"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	RotatedTo  string   `json:"rotated_to,omitempty"`
 }
 
-// CreateAPIKey generates a new API key for the user with the given label.
-// Returns the raw key (only time it's available) and the key ID.
-func CreateAPIKey(ctx context.Context, uid, label string) (rawKey string, keyID string, err error) {
-	c, err := client()
-	if err != nil {
-		return "", "", err
+// Expired reports whether the key is past its expiry date. DynamoDB TTL
+// deletes expired keys, but not right away.
+func (k APIKeyInfo) Expired() bool {
+	return keyExpired(k.ExpiresAt)
+}
+
+func keyExpired(expiresAt string) bool {
+	if expiresAt == "" {
+		return false
 	}
+	t, err := time.Parse(time.RFC3339, expiresAt)
+	return err != nil || !time.Now().Before(t)
+}
 
+// newAPIKey generates a key and the items that store it: the key record in
+// the user's partition and the lookup record keyed by its hash. A zero
+// expiresAt means the key never expires.
+func newAPIKey(uid, label string, scopes []string, expiresAt time.Time) (raw, keyID string, items []types.TransactWriteItem, err error) {
 	keyID, err = randomHex(4) // 8-char hex
 	if err != nil {
-		return "", "", fmt.Errorf("generate key id: %w", err)
+		return "", "", nil, fmt.Errorf("generate key id: %w", err)
 	}
 
-	raw, err := randomHex(32)
+	raw, err = formatAPIKey(keyID)
 	if err != nil {
-		return "", "", fmt.Errorf("generate key: %w", err)
+		return "", "", nil, fmt.Errorf("generate key: %w", err)
 	}
 	hash := hashKey(raw)
 	lookupPK := apikeyLookupPK(hash)
 	now := time.Now().UTC().Format(time.RFC3339)
 
-	_, err = c.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
-		TransactItems: []types.TransactWriteItem{
-			{
-				Put: &types.Put{
-					TableName: aws.String(TableName),
-					Item: map[string]types.AttributeValue{
-						"uid":       &types.AttributeValueMemberS{Value: uid},
-						"sk":        &types.AttributeValueMemberS{Value: "apikey#" + keyID},
-						"KeyHash":   &types.AttributeValueMemberS{Value: hash},
-						"Label":     &types.AttributeValueMemberS{Value: label},
-						"CreatedAt": &types.AttributeValueMemberS{Value: now},
-					},
+	keyItem := map[string]types.AttributeValue{
+		"uid":       &types.AttributeValueMemberS{Value: uid},
+		"sk":        &types.AttributeValueMemberS{Value: "apikey#" + keyID},
+		"KeyHash":   &types.AttributeValueMemberS{Value: hash},
+		"Label":     &types.AttributeValueMemberS{Value: label},
+		"CreatedAt": &types.AttributeValueMemberS{Value: now},
+	}
+	lookupItem := map[string]types.AttributeValue{
+		"uid":   &types.AttributeValueMemberS{Value: lookupPK},
+		"sk":    &types.AttributeValueMemberS{Value: lookupPK},
+		"UID":   &types.AttributeValueMemberS{Value: uid},
+		"KeyID": &types.AttributeValueMemberS{Value: keyID},
+	}
+	if len(scopes) > 0 {
+		keyItem["Scopes"] = &types.AttributeValueMemberSS{Value: scopes}
+		lookupItem["Scopes"] = &types.AttributeValueMemberSS{Value: scopes}
+	}
+	if !expiresAt.IsZero() {
+		for _, item := range []map[string]types.AttributeValue{keyItem, lookupItem} {
+			item["ExpiresAt"] = &types.AttributeValueMemberS{Value: expiresAt.UTC().Format(time.RFC3339)}
+			item["ttl"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", expiresAt.Unix())}
+		}
+	}
+
+	items = []types.TransactWriteItem{
+		{Put: &types.Put{TableName: aws.String(TableName()), Item: keyItem}},
+		{Put: &types.Put{TableName: aws.String(TableName()), Item: lookupItem}},
+	}
+	return raw, keyID, items, nil
+}
+
+// CreateAPIKey generates a new API key for the user with the given label,
+// limited to scopes (no scopes means full access) and expiring at expiresAt
+// (zero means never). Returns the raw key (only time it's available) and the
+// key ID.
+func CreateAPIKey(ctx context.Context, uid, label string, scopes []string, expiresAt time.Time) (rawKey string, keyID string, err error) {
+	c, err := client()
+	if err != nil {
+		return "", "", err
+	}
+
+	raw, keyID, items, err := newAPIKey(uid, label, scopes, expiresAt)
+	if err != nil {
+		return "", "", err
+	}
+	audit, err := auditPut(ctx, uid, AuditEvent{Type: AuditAPIKeyCreated, KeyID: keyID, Detail: label})
+	if err != nil {
+		return "", "", err
+	}
+	items = append(items, types.TransactWriteItem{Put: audit})
+
+	_, err = c.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		return "", "", fmt.Errorf("write api key: %w", err)
+	}
+
+	return raw, keyID, nil
+}
+
+// RotateAPIKey issues a replacement for an API key with the same label and
+// scopes, expiring at expiresAt (zero means never). The old key keeps
+// working for grace, then expires. Returns the new raw key and key ID and
+// when the old key expires, or empty strings if the old key doesn't exist.
+// A key that has already been rotated or has expired gives
+// ErrKeyNotRotatable, so it can't be turned into a fresh, longer-lived key.
+func RotateAPIKey(ctx context.Context, uid, keyID string, grace time.Duration, expiresAt time.Time) (rawKey, newKeyID string, oldExpiresAt time.Time, err error) {
+	c, err := client()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	out, err := c.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: uid},
+			"sk":  &types.AttributeValueMemberS{Value: "apikey#" + keyID},
+		},
+	})
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("get key for rotate: %w", err)
+	}
+	if out.Item == nil {
+		return "", "", time.Time{}, nil
+	}
+	hash, ok := out.Item["KeyHash"].(*types.AttributeValueMemberS)
+	if !ok {
+		return "", "", time.Time{}, fmt.Errorf("invalid api key record")
+	}
+	var label string
+	if v, ok := out.Item["Label"].(*types.AttributeValueMemberS); ok {
+		label = v.Value
+	}
+	var scopes []string
+	if v, ok := out.Item["Scopes"].(*types.AttributeValueMemberSS); ok {
+		scopes = v.Value
+	}
+	if _, rotated := out.Item["RotatedTo"]; rotated {
+		return "", "", time.Time{}, ErrKeyNotRotatable
+	}
+	if v, ok := out.Item["ExpiresAt"].(*types.AttributeValueMemberS); ok && keyExpired(v.Value) {
+		return "", "", time.Time{}, ErrKeyNotRotatable
+	}
+
+	raw, newKeyID, items, err := newAPIKey(uid, label, scopes, expiresAt)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	// Cut the old key's lifetime short, unless it already ends sooner
+	now := time.Now().UTC()
+	oldExpiry := now.Add(grace)
+	if v, ok := out.Item["ExpiresAt"].(*types.AttributeValueMemberS); ok {
+		if t, err := time.Parse(time.RFC3339, v.Value); err == nil && t.Before(oldExpiry) {
+			oldExpiry = t
+		}
+	}
+	expire := func(pk, sk string) types.TransactWriteItem {
+		return types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(TableName()),
+				Key: map[string]types.AttributeValue{
+					"uid": &types.AttributeValueMemberS{Value: pk},
+					"sk":  &types.AttributeValueMemberS{Value: sk},
 				},
-			},
-			{
-				Put: &types.Put{
-					TableName: aws.String(TableName),
-					Item: map[string]types.AttributeValue{
-						"uid":   &types.AttributeValueMemberS{Value: lookupPK},
-						"sk":    &types.AttributeValueMemberS{Value: lookupPK},
-						"UID":   &types.AttributeValueMemberS{Value: uid},
-						"KeyID": &types.AttributeValueMemberS{Value: keyID},
-					},
+				UpdateExpression: aws.String("SET ExpiresAt = :exp, #ttl = :ttl, RotatedTo = :new"),
+				// Concurrent rotations race here, and only one wins
+				ConditionExpression: aws.String("attribute_exists(sk) AND attribute_not_exists(RotatedTo) AND (attribute_not_exists(ExpiresAt) OR ExpiresAt > :now)"),
+				ExpressionAttributeNames: map[string]string{
+					"#ttl": "ttl",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":exp": &types.AttributeValueMemberS{Value: oldExpiry.Format(time.RFC3339)},
+					":ttl": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", oldExpiry.Unix())},
+					":new": &types.AttributeValueMemberS{Value: newKeyID},
+					":now": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
 				},
 			},
-		},
-	})
+		}
+	}
+	lookupPK := apikeyLookupPK(hash.Value)
+	audit, err := auditPut(ctx, uid, AuditEvent{Type: AuditAPIKeyRotated, KeyID: keyID, Detail: "replaced by " + newKeyID})
 	if err != nil {
-		return "", "", fmt.Errorf("write api key: %w", err)
+		return "", "", time.Time{}, err
 	}
+	items = append(items, expire(uid, "apikey#"+keyID), expire(lookupPK, lookupPK), types.TransactWriteItem{Put: audit})
 
-	return raw, keyID, nil
+	_, err = c.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		for _, reason := range canceled.CancellationReasons {
+			if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+				return "", "", time.Time{}, ErrKeyNotRotatable
+			}
+		}
+	}
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("rotate api key: %w", err)
+	}
+	tokenRevoked(hash.Value)
+	return raw, newKeyID, oldExpiry, nil
 }
 
 // ListAPIKeys returns metadata for all API keys belonging to a user.
@@ -100,13 +257,13 @@ func ListAPIKeys(ctx context.Context, uid string) ([]APIKeyInfo, error) {
 	}
 
 	out, err := c.Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String(TableName),
+		TableName:              aws.String(TableName()),
 		KeyConditionExpression: aws.String("uid = :uid AND begins_with(sk, :prefix)"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":uid":    &types.AttributeValueMemberS{Value: uid},
 			":prefix": &types.AttributeValueMemberS{Value: "apikey#"},
 		},
-		ProjectionExpression: aws.String("sk, Label, CreatedAt"),
+		ProjectionExpression: aws.String("sk, Label, CreatedAt, Scopes, ExpiresAt, LastUsedAt, RotatedTo"),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("list api keys: %w", err)
@@ -123,41 +280,201 @@ func ListAPIKeys(ctx context.Context, uid string) ([]APIKeyInfo, error) {
 		if v, ok := item["CreatedAt"].(*types.AttributeValueMemberS); ok {
 			info.CreatedAt = v.Value
 		}
+		if v, ok := item["Scopes"].(*types.AttributeValueMemberSS); ok {
+			info.Scopes = v.Value
+		}
+		if v, ok := item["ExpiresAt"].(*types.AttributeValueMemberS); ok {
+			info.ExpiresAt = v.Value
+		}
+		if v, ok := item["LastUsedAt"].(*types.AttributeValueMemberS); ok {
+			info.LastUsedAt = v.Value
+		}
+		if v, ok := item["RotatedTo"].(*types.AttributeValueMemberS); ok {
+			info.RotatedTo = v.Value
+		}
 		keys = append(keys, info)
 	}
 	return keys, nil
 }
 
-// LookupAPIKey finds the user ID for a raw API key.
-func LookupAPIKey(ctx context.Context, rawKey string) (string, error) {
+// LookupAPIKey finds the user ID and scopes for a raw API key, and when it
+// expires (zero if it doesn't). Expired keys aren't found, and prefixed keys with a bad checksum are rejected without a
+// lookup. The key's LastUsedAt is updated at most once per
+// APIKeyTouchInterval.
+func LookupAPIKey(ctx context.Context, rawKey string) (string, []string, time.Time, error) {
+	var embeddedID string
+	if strings.HasPrefix(rawKey, APIKeyPrefix) {
+		var ok bool
+		if embeddedID, ok = ParseAPIKey(rawKey); !ok {
+			return "", nil, time.Time{}, fmt.Errorf("malformed api key: %w", ErrTokenNotFound)
+		}
+	}
+
 	c, err := client()
 	if err != nil {
-		return "", err
+		return "", nil, time.Time{}, err
 	}
 
 	hash := hashKey(rawKey)
 	lookupPK := apikeyLookupPK(hash)
 
 	out, err := c.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(TableName()),
 		Key: map[string]types.AttributeValue{
 			"uid": &types.AttributeValueMemberS{Value: lookupPK},
 			"sk":  &types.AttributeValueMemberS{Value: lookupPK},
 		},
-		ProjectionExpression: aws.String("UID"),
+		ProjectionExpression: aws.String("UID, KeyID, Scopes, ExpiresAt, LastUsedAt"),
 	})
 	if err != nil {
-		return "", fmt.Errorf("lookup api key: %w", err)
+		return "", nil, time.Time{}, fmt.Errorf("lookup api key: %w", err)
 	}
 	if out.Item == nil {
-		return "", fmt.Errorf("api key not found")
+		return "", nil, time.Time{}, fmt.Errorf("api key: %w", ErrTokenNotFound)
 	}
 
 	uid, ok := out.Item["UID"].(*types.AttributeValueMemberS)
 	if !ok {
-		return "", fmt.Errorf("invalid api key record")
+		return "", nil, time.Time{}, fmt.Errorf("invalid api key record")
+	}
+	var expiresAt time.Time
+	if v, ok := out.Item["ExpiresAt"].(*types.AttributeValueMemberS); ok {
+		if keyExpired(v.Value) {
+			return "", nil, time.Time{}, fmt.Errorf("api key expired: %w", ErrTokenNotFound)
+		}
+		expiresAt, _ = time.Parse(time.RFC3339, v.Value)
 	}
-	return uid.Value, nil
+	keyID, _ := out.Item["KeyID"].(*types.AttributeValueMemberS)
+	if embeddedID != "" && (keyID == nil || keyID.Value != embeddedID) {
+		return "", nil, time.Time{}, fmt.Errorf("invalid api key record")
+	}
+	var scopes []string
+	if v, ok := out.Item["Scopes"].(*types.AttributeValueMemberSS); ok {
+		scopes = v.Value
+	}
+
+	var lastUsed time.Time
+	if v, ok := out.Item["LastUsedAt"].(*types.AttributeValueMemberS); ok {
+		lastUsed, _ = time.Parse(time.RFC3339, v.Value)
+	}
+	if keyID != nil && time.Since(lastUsed) > APIKeyTouchInterval {
+		touchAPIKey(ctx, c, uid.Value, keyID.Value, lookupPK)
+	}
+	return uid.Value, scopes, expiresAt, nil
+}
+
+// touchAPIKey records that a key was just used, on both its key and lookup
+// records. The lookup record's condition keeps concurrent requests from all
+// writing. Failures are ignored; this is bookkeeping, not auth.
+func touchAPIKey(ctx context.Context, c *dynamodb.Client, uid, keyID, lookupPK string) {
+	now := time.Now().UTC()
+	_, _ = c.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Update: &types.Update{
+					TableName: aws.String(TableName()),
+					Key: map[string]types.AttributeValue{
+						"uid": &types.AttributeValueMemberS{Value: lookupPK},
+						"sk":  &types.AttributeValueMemberS{Value: lookupPK},
+					},
+					UpdateExpression:    aws.String("SET LastUsedAt = :now"),
+					ConditionExpression: aws.String("attribute_exists(sk) AND (attribute_not_exists(LastUsedAt) OR LastUsedAt < :cutoff)"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":now":    &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+						":cutoff": &types.AttributeValueMemberS{Value: now.Add(-APIKeyTouchInterval).Format(time.RFC3339)},
+					},
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName: aws.String(TableName()),
+					Key: map[string]types.AttributeValue{
+						"uid": &types.AttributeValueMemberS{Value: uid},
+						"sk":  &types.AttributeValueMemberS{Value: "apikey#" + keyID},
+					},
+					UpdateExpression:    aws.String("SET LastUsedAt = :now"),
+					ConditionExpression: aws.String("attribute_exists(sk)"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":now": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+					},
+				},
+			},
+		},
+	})
+}
+
+// FindAPIKey returns the owner and key ID of a raw API key, or empty strings
+// if it doesn't exist.
+func FindAPIKey(ctx context.Context, rawKey string) (uid, keyID string, err error) {
+	c, err := client()
+	if err != nil {
+		return "", "", err
+	}
+
+	lookupPK := apikeyLookupPK(hashKey(rawKey))
+	out, err := c.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: lookupPK},
+			"sk":  &types.AttributeValueMemberS{Value: lookupPK},
+		},
+		ProjectionExpression: aws.String("UID, KeyID"),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("find api key: %w", err)
+	}
+	if v, ok := out.Item["UID"].(*types.AttributeValueMemberS); ok {
+		uid = v.Value
+	}
+	if v, ok := out.Item["KeyID"].(*types.AttributeValueMemberS); ok {
+		keyID = v.Value
+	}
+	return uid, keyID, nil
+}
+
+// GetAPIKey returns the metadata for one of a user's API keys, or nil if it
+// doesn't exist.
+func GetAPIKey(ctx context.Context, uid, keyID string) (*APIKeyInfo, error) {
+	c, err := client()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := c.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: uid},
+			"sk":  &types.AttributeValueMemberS{Value: "apikey#" + keyID},
+		},
+		ProjectionExpression: aws.String("Label, CreatedAt, Scopes, ExpiresAt, LastUsedAt, RotatedTo"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get api key: %w", err)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+
+	info := &APIKeyInfo{KeyID: keyID}
+	if v, ok := out.Item["Label"].(*types.AttributeValueMemberS); ok {
+		info.Label = v.Value
+	}
+	if v, ok := out.Item["CreatedAt"].(*types.AttributeValueMemberS); ok {
+		info.CreatedAt = v.Value
+	}
+	if v, ok := out.Item["Scopes"].(*types.AttributeValueMemberSS); ok {
+		info.Scopes = v.Value
+	}
+	if v, ok := out.Item["ExpiresAt"].(*types.AttributeValueMemberS); ok {
+		info.ExpiresAt = v.Value
+	}
+	if v, ok := out.Item["LastUsedAt"].(*types.AttributeValueMemberS); ok {
+		info.LastUsedAt = v.Value
+	}
+	if v, ok := out.Item["RotatedTo"].(*types.AttributeValueMemberS); ok {
+		info.RotatedTo = v.Value
+	}
+	return info, nil
 }
 
 // DeleteAPIKey revokes a specific API key by key ID.
@@ -169,7 +486,7 @@ func DeleteAPIKey(ctx context.Context, uid, keyID string) error {
 
 	// Get the key hash so we can delete the lookup record
 	out, err := c.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(TableName()),
 		Key: map[string]types.AttributeValue{
 			"uid": &types.AttributeValueMemberS{Value: uid},
 			"sk":  &types.AttributeValueMemberS{Value: "apikey#" + keyID},
@@ -188,12 +505,16 @@ func DeleteAPIKey(ctx context.Context, uid, keyID string) error {
 		return nil
 	}
 	lookupPK := apikeyLookupPK(hash.Value)
+	audit, err := auditPut(ctx, uid, AuditEvent{Type: AuditAPIKeyDeleted, KeyID: keyID})
+	if err != nil {
+		return err
+	}
 
 	_, err = c.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
 		TransactItems: []types.TransactWriteItem{
 			{
 				Delete: &types.Delete{
-					TableName: aws.String(TableName),
+					TableName: aws.String(TableName()),
 					Key: map[string]types.AttributeValue{
 						"uid": &types.AttributeValueMemberS{Value: uid},
 						"sk":  &types.AttributeValueMemberS{Value: "apikey#" + keyID},
@@ -202,18 +523,20 @@ func DeleteAPIKey(ctx context.Context, uid, keyID string) error {
 			},
 			{
 				Delete: &types.Delete{
-					TableName: aws.String(TableName),
+					TableName: aws.String(TableName()),
 					Key: map[string]types.AttributeValue{
 						"uid": &types.AttributeValueMemberS{Value: lookupPK},
 						"sk":  &types.AttributeValueMemberS{Value: lookupPK},
 					},
 				},
 			},
+			{Put: audit},
 		},
 	})
 	if err != nil {
 		return fmt.Errorf("delete api key: %w", err)
 	}
+	tokenRevoked(hash.Value)
 	return nil
 }
 
@@ -0,0 +1,172 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/BrianLeishman/justlog.io/go/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Audit event types.
+const (
+	AuditAPIKeyCreated     = "api_key.created"
+	AuditAPIKeyRotated     = "api_key.rotated"
+	AuditAPIKeyDeleted     = "api_key.deleted"
+	AuditClientRegistered  = "oauth_client.registered"
+	AuditClientDeleted     = "oauth_client.deleted"
+	AuditTokenIssued       = "oauth.token_issued"
+	AuditCodeReuse         = "oauth.code_reuse"
+	AuditRefreshReuse      = "oauth.refresh_reuse"
+	AuditConnectionRevoked = "oauth.connection_revoked"
+	AuditAuthFailed        = "auth.failed"
+)
+
+// AuditGlobal is the stream for events that don't belong to a user, like
+// client registrations and failed sign-ins with unknown credentials.
+const AuditGlobal = "audit#global"
+
+// AuditEvent is one entry in an audit stream. Events are only ever added;
+// they expire after the audit_retention setting.
+type AuditEvent struct {
+	ID       string `json:"id"`
+	Time     string `json:"time"`
+	Type     string `json:"type"`
+	ClientID string `json:"client_id,omitempty"`
+	KeyID    string `json:"key_id,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// auditIDLayout is a fixed-width timestamp, so event IDs sort by time.
+const auditIDLayout = "2006-01-02T15:04:05.000000000Z"
+
+type auditIPKey struct{}
+
+// WithAuditIP records the address a request came from on ctx, so audit
+// events written while handling it include it.
+func WithAuditIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, auditIPKey{}, ip)
+}
+
+// AuditIP returns the address recorded on ctx by WithAuditIP.
+func AuditIP(ctx context.Context) string {
+	ip, _ := ctx.Value(auditIPKey{}).(string)
+	return ip
+}
+
+// auditPut builds the item for an event in uid's stream, filling in its ID,
+// time and IP.
+func auditPut(ctx context.Context, uid string, e AuditEvent) (*types.Put, error) {
+	suffix, err := randomHex(4)
+	if err != nil {
+		return nil, fmt.Errorf("generate audit id: %w", err)
+	}
+	now := time.Now().UTC()
+	e.Time = now.Format(time.RFC3339)
+	e.ID = now.Format(auditIDLayout) + "#" + suffix
+	if e.IP == "" {
+		e.IP = AuditIP(ctx)
+	}
+	expires := now.Add(time.Duration(config.Get().AuditRetention))
+
+	item := map[string]types.AttributeValue{
+		"uid":  &types.AttributeValueMemberS{Value: uid},
+		"sk":   &types.AttributeValueMemberS{Value: "audit#" + e.ID},
+		"Time": &types.AttributeValueMemberS{Value: e.Time},
+		"Type": &types.AttributeValueMemberS{Value: e.Type},
+		"ttl":  &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", expires.Unix())},
+	}
+	for name, v := range map[string]string{
+		"ClientID": e.ClientID,
+		"KeyID":    e.KeyID,
+		"IP":       e.IP,
+		"Detail":   e.Detail,
+	} {
+		if v != "" {
+			item[name] = &types.AttributeValueMemberS{Value: v}
+		}
+	}
+	return &types.Put{
+		TableName:           aws.String(TableName()),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(sk)"),
+	}, nil
+}
+
+// RecordAudit appends an event to uid's audit stream, or to AuditGlobal's.
+func RecordAudit(ctx context.Context, uid string, e AuditEvent) error {
+	db, err := client()
+	if err != nil {
+		return err
+	}
+
+	put, err := auditPut(ctx, uid, e)
+	if err != nil {
+		return err
+	}
+	_, err = db.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           put.TableName,
+		Item:                put.Item,
+		ConditionExpression: put.ConditionExpression,
+	})
+	if err != nil {
+		return fmt.Errorf("record audit event: %w", err)
+	}
+	return nil
+}
+
+// ListAuditEvents returns up to limit of uid's audit events, newest first.
+// A non-empty before only returns events older than the one with that ID.
+func ListAuditEvents(ctx context.Context, uid, before string, limit int) ([]AuditEvent, error) {
+	db, err := client()
+	if err != nil {
+		return nil, err
+	}
+
+	in := &dynamodb.QueryInput{
+		TableName:              aws.String(TableName()),
+		KeyConditionExpression: aws.String("uid = :uid AND begins_with(sk, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":uid":    &types.AttributeValueMemberS{Value: uid},
+			":prefix": &types.AttributeValueMemberS{Value: "audit#"},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	}
+	if before != "" {
+		// Reading backwards, the query resumes at the next older event
+		in.ExclusiveStartKey = map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: uid},
+			"sk":  &types.AttributeValueMemberS{Value: "audit#" + before},
+		}
+	}
+
+	out, err := db.Query(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("list audit events: %w", err)
+	}
+
+	events := make([]AuditEvent, 0, len(out.Items))
+	for _, item := range out.Items {
+		sk := item["sk"].(*types.AttributeValueMemberS).Value
+		e := AuditEvent{ID: sk[len("audit#"):]}
+		for name, dst := range map[string]*string{
+			"Time":     &e.Time,
+			"Type":     &e.Type,
+			"ClientID": &e.ClientID,
+			"KeyID":    &e.KeyID,
+			"IP":       &e.IP,
+			"Detail":   &e.Detail,
+		} {
+			if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+				*dst = v.Value
+			}
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
@@ -4,18 +4,28 @@ import (
 	"context"
 	"sync"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/BrianLeishman/justlog.io/go/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 )
 
-const TableName = "justlog"
+// TableName is the table everything lives in, from config.
+func TableName() string {
+	return config.Get().TableName
+}
 
 var client = sync.OnceValues(func() (*dynamodb.Client, error) {
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background())
 	if err != nil {
 		return nil, err
 	}
-	return dynamodb.NewFromConfig(cfg), nil
+	endpoint := config.Get().DynamoEndpoint
+	return dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}), nil
 })
 
 func Client() (*dynamodb.Client, error) {
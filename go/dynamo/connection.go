@@ -0,0 +1,140 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Connection is an OAuth client a user has authorized, with every credential
+// it holds rolled up: its grants, plus any API keys the OAuth server handed
+// out before it issued its own tokens.
+type Connection struct {
+	ClientID     string   `json:"client_id"`
+	ClientName   string   `json:"client_name"`
+	RegisteredAt string   `json:"registered_at,omitempty"`
+	ConnectedAt  string   `json:"connected_at"`
+	LastUsedAt   string   `json:"last_used_at,omitempty"`
+	Scopes       []string `json:"scopes"`
+	Credentials  int      `json:"credentials"`
+
+	grantIDs []string
+	keyIDs   []string
+}
+
+// ListConnections returns a user's connected OAuth clients, most recently
+// used first.
+func ListConnections(ctx context.Context, uid string) ([]Connection, error) {
+	grants, err := ListGrants(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ListAPIKeys(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	byClient := map[string]*Connection{}
+	conn := func(clientID string) *Connection {
+		c, ok := byClient[clientID]
+		if !ok {
+			c = &Connection{ClientID: clientID}
+			byClient[clientID] = c
+		}
+		return c
+	}
+	seen := func(c *Connection, createdAt, lastUsedAt, scope string) {
+		if c.ConnectedAt == "" || (createdAt != "" && createdAt < c.ConnectedAt) {
+			c.ConnectedAt = createdAt
+		}
+		if lastUsedAt > c.LastUsedAt {
+			c.LastUsedAt = lastUsedAt
+		}
+		for _, s := range strings.Fields(scope) {
+			if !slices.Contains(c.Scopes, s) {
+				c.Scopes = append(c.Scopes, s)
+			}
+		}
+		c.Credentials++
+	}
+
+	for _, g := range grants {
+		c := conn(g.ClientID)
+		c.grantIDs = append(c.grantIDs, g.GrantID)
+		seen(c, g.CreatedAt, g.LastUsedAt, g.Scope)
+	}
+	for _, k := range keys {
+		clientID, ok := strings.CutPrefix(k.Label, oauthKeyLabel)
+		if !ok {
+			continue
+		}
+		c := conn(clientID)
+		c.keyIDs = append(c.keyIDs, k.KeyID)
+		scope := strings.Join(k.Scopes, " ")
+		if scope == "" {
+			scope = "justlog"
+		}
+		seen(c, k.CreatedAt, "", scope)
+	}
+
+	conns := make([]Connection, 0, len(byClient))
+	for _, c := range byClient {
+		client, err := GetOAuthClient(ctx, c.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		if client != nil {
+			c.ClientName = client.ClientName
+			c.RegisteredAt = client.CreatedAt
+		}
+		if c.ClientName == "" {
+			c.ClientName = "Unnamed app"
+		}
+		sort.Strings(c.Scopes)
+		conns = append(conns, *c)
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		a, b := conns[i].LastUsedAt, conns[j].LastUsedAt
+		if a == "" {
+			a = conns[i].ConnectedAt
+		}
+		if b == "" {
+			b = conns[j].ConnectedAt
+		}
+		return a > b
+	})
+	return conns, nil
+}
+
+// RevokeConnection revokes every grant and OAuth-issued API key a user has
+// given to a client, and forgets their consent for it. It returns false if
+// the client had nothing to revoke.
+func RevokeConnection(ctx context.Context, uid, clientID string) (bool, error) {
+	// Reconnecting should ask for consent again
+	if err := DeleteConsent(ctx, uid, clientID); err != nil {
+		return false, fmt.Errorf("revoke connection: %w", err)
+	}
+
+	conns, err := ListConnections(ctx, uid)
+	if err != nil {
+		return false, err
+	}
+	i := slices.IndexFunc(conns, func(c Connection) bool { return c.ClientID == clientID })
+	if i < 0 {
+		return false, nil
+	}
+
+	for _, id := range conns[i].grantIDs {
+		if err := RevokeGrant(ctx, uid, id); err != nil {
+			return false, fmt.Errorf("revoke connection: %w", err)
+		}
+	}
+	for _, id := range conns[i].keyIDs {
+		if err := DeleteAPIKey(ctx, uid, id); err != nil {
+			return false, fmt.Errorf("revoke connection: %w", err)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,96 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func consentSK(clientID string) string {
+	return "consent#" + clientID
+}
+
+// GetConsent returns the scopes a user has said an OAuth client may have
+// without asking again, or nil if they haven't.
+func GetConsent(ctx context.Context, uid, clientID string) ([]string, error) {
+	db, err := client()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: uid},
+			"sk":  &types.AttributeValueMemberS{Value: consentSK(clientID)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get consent: %w", err)
+	}
+	v, _ := out.Item["Scope"].(*types.AttributeValueMemberS)
+	if v == nil {
+		return nil, nil
+	}
+	return strings.Fields(v.Value), nil
+}
+
+// AddConsent remembers that a user lets an OAuth client have scopes, on top
+// of any it already had.
+func AddConsent(ctx context.Context, uid, clientID string, scopes []string) error {
+	db, err := client()
+	if err != nil {
+		return err
+	}
+
+	existing, err := GetConsent(ctx, uid, clientID)
+	if err != nil {
+		return err
+	}
+	for _, s := range scopes {
+		if !slices.Contains(existing, s) {
+			existing = append(existing, s)
+		}
+	}
+
+	_, err = db.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(TableName()),
+		Item: map[string]types.AttributeValue{
+			"uid":       &types.AttributeValueMemberS{Value: uid},
+			"sk":        &types.AttributeValueMemberS{Value: consentSK(clientID)},
+			"Scope":     &types.AttributeValueMemberS{Value: strings.Join(existing, " ")},
+			"UpdatedAt": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("put consent: %w", err)
+	}
+	return nil
+}
+
+// DeleteConsent forgets a user's remembered consent for an OAuth client, so
+// the next authorization asks again.
+func DeleteConsent(ctx context.Context, uid, clientID string) error {
+	db, err := client()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: uid},
+			"sk":  &types.AttributeValueMemberS{Value: consentSK(clientID)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("delete consent: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package dynamo
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// How long a device code is valid, and the minimum interval clients must
+// leave between polls (RFC 8628 section 3.5).
+const (
+	DeviceCodeTTL      = 10 * time.Minute
+	DevicePollInterval = 5 * time.Second
+)
+
+// States of a device authorization.
+const (
+	DeviceStatusPending  = "pending"
+	DeviceStatusApproved = "approved"
+	DeviceStatusDenied   = "denied"
+)
+
+// User codes use consonants only, so they can't spell words and don't mix
+// up O/0 or I/1 (RFC 8628 section 6.1).
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// ErrSlowDown means a device is polling faster than DevicePollInterval.
+var ErrSlowDown = errors.New("polling too fast")
+
+// DeviceAuthorization is a pending RFC 8628 device authorization. It's
+// stored under the hash of its device code, with a second record mapping
+// the user code to it.
+type DeviceAuthorization struct {
+	// DeviceCode is only set on a freshly created authorization.
+	DeviceCode string
+	UserCode   string
+	ClientID   string
+	Scope      string
+	Status     string
+	// UID is set once the user approves.
+	UID       string
+	ExpiresAt time.Time
+}
+
+func deviceLookupPK(hash string) string {
+	return "oauth_device#" + hash
+}
+
+func userCodePK(userCode string) string {
+	return "oauth_user_code#" + userCode
+}
+
+// NormalizeUserCode uppercases a user code as typed and restores its dash,
+// so "bcdf ghjk" finds BCDF-GHJK.
+func NormalizeUserCode(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		if strings.ContainsRune(userCodeAlphabet, r) {
+			return r
+		}
+		return -1
+	}, s)
+	if len(s) != 8 {
+		return s
+	}
+	return s[:4] + "-" + s[4:]
+}
+
+func newUserCode() (string, error) {
+	size := big.NewInt(int64(len(userCodeAlphabet)))
+	b := make([]byte, 8)
+	for i := range b {
+		v, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		b[i] = userCodeAlphabet[v.Int64()]
+	}
+	return string(b[:4]) + "-" + string(b[4:]), nil
+}
+
+// CreateDeviceAuthorization starts a device authorization for a client.
+func CreateDeviceAuthorization(ctx context.Context, clientID, scope string) (*DeviceAuthorization, error) {
+	db, err := client()
+	if err != nil {
+		return nil, err
+	}
+
+	deviceCode, err := randomHex(32)
+	if err != nil {
+		return nil, fmt.Errorf("generate device code: %w", err)
+	}
+	hash := hashKey(deviceCode)
+	expiresAt := time.Now().UTC().Add(DeviceCodeTTL).Truncate(time.Second)
+	ttl := fmt.Sprintf("%d", expiresAt.Unix())
+
+	// User codes are short enough to collide; try a few
+	for range 3 {
+		userCode, err := newUserCode()
+		if err != nil {
+			return nil, fmt.Errorf("generate user code: %w", err)
+		}
+		pk, userPK := deviceLookupPK(hash), userCodePK(userCode)
+		_, err = db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []types.TransactWriteItem{
+				{Put: &types.Put{
+					TableName: aws.String(TableName()),
+					Item: map[string]types.AttributeValue{
+						"uid":       &types.AttributeValueMemberS{Value: pk},
+						"sk":        &types.AttributeValueMemberS{Value: pk},
+						"UserCode":  &types.AttributeValueMemberS{Value: userCode},
+						"ClientID":  &types.AttributeValueMemberS{Value: clientID},
+						"Scope":     &types.AttributeValueMemberS{Value: scope},
+						"Status":    &types.AttributeValueMemberS{Value: DeviceStatusPending},
+						"ExpiresAt": &types.AttributeValueMemberS{Value: expiresAt.Format(time.RFC3339)},
+						"ttl":       &types.AttributeValueMemberN{Value: ttl},
+					},
+				}},
+				{Put: &types.Put{
+					TableName: aws.String(TableName()),
+					Item: map[string]types.AttributeValue{
+						"uid":        &types.AttributeValueMemberS{Value: userPK},
+						"sk":         &types.AttributeValueMemberS{Value: userPK},
+						"DeviceHash": &types.AttributeValueMemberS{Value: hash},
+						"ttl":        &types.AttributeValueMemberN{Value: ttl},
+					},
+					ConditionExpression: aws.String("attribute_not_exists(uid)"),
+				}},
+			},
+		})
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("create device authorization: %w", err)
+		}
+		return &DeviceAuthorization{
+			DeviceCode: deviceCode,
+			UserCode:   userCode,
+			ClientID:   clientID,
+			Scope:      scope,
+			Status:     DeviceStatusPending,
+			ExpiresAt:  expiresAt,
+		}, nil
+	}
+	return nil, errors.New("create device authorization: no free user code")
+}
+
+func deviceFromItem(item map[string]types.AttributeValue) *DeviceAuthorization {
+	d := &DeviceAuthorization{}
+	if v, ok := item["UserCode"].(*types.AttributeValueMemberS); ok {
+		d.UserCode = v.Value
+	}
+	if v, ok := item["ClientID"].(*types.AttributeValueMemberS); ok {
+		d.ClientID = v.Value
+	}
+	if v, ok := item["Scope"].(*types.AttributeValueMemberS); ok {
+		d.Scope = v.Value
+	}
+	if v, ok := item["Status"].(*types.AttributeValueMemberS); ok {
+		d.Status = v.Value
+	}
+	if v, ok := item["UID"].(*types.AttributeValueMemberS); ok {
+		d.UID = v.Value
+	}
+	if v, ok := item["ExpiresAt"].(*types.AttributeValueMemberS); ok {
+		d.ExpiresAt, _ = time.Parse(time.RFC3339, v.Value)
+	}
+	return d
+}
+
+func deviceHashForUserCode(ctx context.Context, db *dynamodb.Client, userCode string) (string, error) {
+	pk := userCodePK(NormalizeUserCode(userCode))
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("get user code: %w", err)
+	}
+	v, _ := out.Item["DeviceHash"].(*types.AttributeValueMemberS)
+	if v == nil {
+		return "", nil
+	}
+	return v.Value, nil
+}
+
+// GetDeviceAuthorization finds a pending device authorization by the user
+// code the user typed. It returns nil if the code is unknown, expired, or
+// already used.
+func GetDeviceAuthorization(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	db, err := client()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := deviceHashForUserCode(ctx, db, userCode)
+	if err != nil || hash == "" {
+		return nil, err
+	}
+
+	pk := deviceLookupPK(hash)
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get device authorization: %w", err)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	d := deviceFromItem(out.Item)
+	if d.Status != DeviceStatusPending || time.Now().After(d.ExpiresAt) {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// CompleteDeviceAuthorization records the user's decision on a pending
+// device authorization. It returns ErrInvalidGrant if the authorization is
+// no longer pending.
+func CompleteDeviceAuthorization(ctx context.Context, userCode, uid string, approve bool) error {
+	db, err := client()
+	if err != nil {
+		return err
+	}
+	hash, err := deviceHashForUserCode(ctx, db, userCode)
+	if err != nil {
+		return err
+	}
+	if hash == "" {
+		return ErrInvalidGrant
+	}
+
+	status := DeviceStatusDenied
+	if approve {
+		status = DeviceStatusApproved
+	}
+	pk := deviceLookupPK(hash)
+	_, err = db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+		UpdateExpression:    aws.String("SET #status = :status, UID = :uid"),
+		ConditionExpression: aws.String("#status = :pending AND ExpiresAt > :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":  &types.AttributeValueMemberS{Value: status},
+			":uid":     &types.AttributeValueMemberS{Value: uid},
+			":pending": &types.AttributeValueMemberS{Value: DeviceStatusPending},
+			":now":     &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return ErrInvalidGrant
+	}
+	if err != nil {
+		return fmt.Errorf("complete device authorization: %w", err)
+	}
+	return nil
+}
+
+// PollDeviceAuthorization records a client's poll and returns the
+// authorization's state. It returns nil if the device code is unknown, and
+// ErrSlowDown along with the state if the previous poll was too recent.
+func PollDeviceAuthorization(ctx context.Context, deviceCode string) (*DeviceAuthorization, error) {
+	db, err := client()
+	if err != nil {
+		return nil, err
+	}
+
+	pk := deviceLookupPK(hashKey(deviceCode))
+	now := time.Now().UTC()
+	out, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+		UpdateExpression:    aws.String("SET LastPolledAt = :now"),
+		ConditionExpression: aws.String("attribute_exists(uid)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("poll device authorization: %w", err)
+	}
+
+	d := deviceFromItem(out.Attributes)
+	if v, ok := out.Attributes["LastPolledAt"].(*types.AttributeValueMemberS); ok {
+		if last, err := time.Parse(time.RFC3339Nano, v.Value); err == nil && now.Sub(last) < DevicePollInterval {
+			return d, ErrSlowDown
+		}
+	}
+	return d, nil
+}
+
+// RedeemDeviceAuthorization deletes an approved device authorization so its
+// device code can be exchanged for tokens only once. It returns
+// ErrInvalidGrant if another poll redeemed it first.
+func RedeemDeviceAuthorization(ctx context.Context, deviceCode string) error {
+	db, err := client()
+	if err != nil {
+		return err
+	}
+
+	pk := deviceLookupPK(hashKey(deviceCode))
+	_, err = db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+		ConditionExpression: aws.String("#status = :approved"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":approved": &types.AttributeValueMemberS{Value: DeviceStatusApproved},
+		},
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return ErrInvalidGrant
+	}
+	if err != nil {
+		return fmt.Errorf("redeem device authorization: %w", err)
+	}
+	return nil
+}
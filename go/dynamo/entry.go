@@ -2,7 +2,9 @@ package dynamo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -13,31 +15,43 @@ import (
 )
 
 type Entry struct {
-	UID         string  `dynamodbav:"uid" json:"-"`
-	SK          string  `dynamodbav:"sk" json:"sk"`
-	Type        string  `dynamodbav:"type" json:"type"`
-	Description string  `dynamodbav:"description,omitempty" json:"description,omitempty"`
-	Calories    float64 `dynamodbav:"calories,omitempty" json:"calories,omitempty"`
-	Protein     float64 `dynamodbav:"protein,omitempty" json:"protein,omitempty"`
-	Carbs       float64 `dynamodbav:"carbs,omitempty" json:"carbs,omitempty"`
-	NetCarbs    float64 `dynamodbav:"netCarbs,omitempty" json:"net_carbs,omitempty"`
-	Fat         float64 `dynamodbav:"fat,omitempty" json:"fat,omitempty"`
-	Fiber       float64 `dynamodbav:"fiber,omitempty" json:"fiber,omitempty"`
-	Caffeine    float64 `dynamodbav:"caffeine,omitempty" json:"caffeine,omitempty"`
-	Cholesterol float64 `dynamodbav:"cholesterol,omitempty" json:"cholesterol,omitempty"`
-	Sodium      float64 `dynamodbav:"sodium,omitempty" json:"sodium,omitempty"`
-	Sugar       float64 `dynamodbav:"sugar,omitempty" json:"sugar,omitempty"`
-	Duration    float64 `dynamodbav:"duration,omitempty" json:"duration,omitempty"`
-	Value       float64 `dynamodbav:"value,omitempty" json:"value,omitempty"`
-	Unit        string  `dynamodbav:"unit,omitempty" json:"unit,omitempty"`
-	Notes       string  `dynamodbav:"notes,omitempty" json:"notes,omitempty"`
-	CreatedAt   string  `dynamodbav:"createdAt" json:"created_at"`
+	UID         string   `dynamodbav:"uid" json:"-"`
+	SK          string   `dynamodbav:"sk" json:"sk"`
+	Type        string   `dynamodbav:"type" json:"type"`
+	Description string   `dynamodbav:"description,omitempty" json:"description,omitempty"`
+	Calories    float64  `dynamodbav:"calories,omitempty" json:"calories,omitempty"`
+	Protein     float64  `dynamodbav:"protein,omitempty" json:"protein,omitempty"`
+	Carbs       float64  `dynamodbav:"carbs,omitempty" json:"carbs,omitempty"`
+	NetCarbs    float64  `dynamodbav:"netCarbs,omitempty" json:"net_carbs,omitempty"`
+	Fat         float64  `dynamodbav:"fat,omitempty" json:"fat,omitempty"`
+	Fiber       float64  `dynamodbav:"fiber,omitempty" json:"fiber,omitempty"`
+	Caffeine    float64  `dynamodbav:"caffeine,omitempty" json:"caffeine,omitempty"`
+	Cholesterol float64  `dynamodbav:"cholesterol,omitempty" json:"cholesterol,omitempty"`
+	Sodium      float64  `dynamodbav:"sodium,omitempty" json:"sodium,omitempty"`
+	Sugar       float64  `dynamodbav:"sugar,omitempty" json:"sugar,omitempty"`
+	Duration    float64  `dynamodbav:"duration,omitempty" json:"duration,omitempty"`
+	Value       float64  `dynamodbav:"value,omitempty" json:"value,omitempty"`
+	Unit        string   `dynamodbav:"unit,omitempty" json:"unit,omitempty"`
+	Notes       string   `dynamodbav:"notes,omitempty" json:"notes,omitempty"`
+	Meal        string   `dynamodbav:"meal,omitempty" json:"meal,omitempty"`
+	Tags        []string `dynamodbav:"tags,omitempty,stringset" json:"tags,omitempty"`
+	CreatedAt   string   `dynamodbav:"createdAt" json:"created_at"`
 }
 
 func MakeSK(entryType string) string {
 	return entryType + "#" + xid.New().String()
 }
 
+// ErrNotEntry means a sort key passed as an entry's belongs to something
+// else in the user's partition, such as an API key or audit event.
+var ErrNotEntry = errors.New("not an entry")
+
+// isEntrySK reports whether sk is a food, exercise or weight entry's.
+func isEntrySK(sk string) bool {
+	t, id, ok := strings.Cut(sk, "#")
+	return ok && id != "" && (t == "food" || t == "exercise" || t == "weight")
+}
+
 func PutEntry(ctx context.Context, entry Entry) error {
 	db, err := Client()
 	if err != nil {
@@ -49,9 +63,11 @@ func PutEntry(ctx context.Context, entry Entry) error {
 		return fmt.Errorf("marshal entry: %w", err)
 	}
 
-	_, err = db.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(TableName),
-		Item:      item,
+	_, err = db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{TableName: aws.String(TableName()), Item: item}},
+			{Put: &types.Put{TableName: aws.String(TableName()), Item: searchIndexItem(entry)}},
+		},
 	})
 	return err
 }
@@ -63,7 +79,7 @@ func GetEntries(ctx context.Context, uid, entryType string, from, to time.Time)
 	}
 
 	out, err := db.Query(ctx, &dynamodb.QueryInput{
-		TableName:              aws.String(TableName),
+		TableName:              aws.String(TableName()),
 		KeyConditionExpression: aws.String("uid = :uid AND begins_with(sk, :prefix)"),
 		FilterExpression:       aws.String("createdAt BETWEEN :from AND :to"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
@@ -85,10 +101,48 @@ func GetEntries(ctx context.Context, uid, entryType string, from, to time.Time)
 	return entries, nil
 }
 
-func UpdateEntry(ctx context.Context, uid, sk string, fields map[string]interface{}) error {
+// GetEntry returns a single entry by sort key, or nil if it doesn't exist.
+func GetEntry(ctx context.Context, uid, sk string) (*Entry, error) {
+	db, err := Client()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: uid},
+			"sk":  &types.AttributeValueMemberS{Value: sk},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+
+	var entry Entry
+	if err := attributevalue.UnmarshalMap(out.Item, &entry); err != nil {
+		return nil, fmt.Errorf("unmarshal entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// ErrEntryNotFound means there's no entry with the given sort key and type.
+var ErrEntryNotFound = errors.New("entry not found")
+
+// UpdateEntry sets fields on an existing entry of type entryType, keeping
+// its search index item in sync. It returns ErrEntryNotFound if there's no
+// such entry, rather than creating one, so a tool scoped to one entry type
+// can't change another's.
+func UpdateEntry(ctx context.Context, uid, entryType, sk string, fields map[string]interface{}) error {
 	if len(fields) == 0 {
 		return nil
 	}
+	if !isEntrySK(sk) {
+		return ErrNotEntry
+	}
 
 	db, err := Client()
 	if err != nil {
@@ -108,38 +162,88 @@ func UpdateEntry(ctx context.Context, uid, sk string, fields map[string]interfac
 		expr += alias + " = " + placeholder
 		names[alias] = k
 
-		av, err := attributevalue.Marshal(v)
-		if err != nil {
-			return fmt.Errorf("marshal field %s: %w", k, err)
+		// String slices (tags) are stored as string sets, matching PutEntry
+		if ss, ok := v.([]string); ok {
+			values[placeholder] = &types.AttributeValueMemberSS{Value: ss}
+		} else {
+			av, err := attributevalue.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("marshal field %s: %w", k, err)
+			}
+			values[placeholder] = av
 		}
-		values[placeholder] = av
 		i++
 	}
+	names["#type"] = "type"
+	values[":type"] = &types.AttributeValueMemberS{Value: entryType}
 
-	_, err = db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String(TableName),
+	out, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(TableName()),
 		Key: map[string]types.AttributeValue{
 			"uid": &types.AttributeValueMemberS{Value: uid},
 			"sk":  &types.AttributeValueMemberS{Value: sk},
 		},
 		UpdateExpression:          aws.String(expr),
+		ConditionExpression:       aws.String("attribute_exists(sk) AND #type = :type"),
 		ExpressionAttributeNames:  names,
 		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
 	})
-	return err
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return ErrEntryNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// Keep the search index in sync with the fields it covers
+	_, desc := fields["description"]
+	_, notes := fields["notes"]
+	_, created := fields["createdAt"]
+	if !desc && !notes && !created {
+		return nil
+	}
+	var entry Entry
+	if err := attributevalue.UnmarshalMap(out.Attributes, &entry); err != nil {
+		return fmt.Errorf("unmarshal updated entry: %w", err)
+	}
+	return PutSearchIndex(ctx, entry)
 }
 
+// DeleteEntry deletes an entry and its search index item. Sort keys that
+// aren't an entry's give ErrNotEntry, so credentials and the audit log
+// can't be deleted through it.
 func DeleteEntry(ctx context.Context, uid, sk string) error {
+	if !isEntrySK(sk) {
+		return ErrNotEntry
+	}
+
 	db, err := Client()
 	if err != nil {
 		return err
 	}
 
-	_, err = db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
-		TableName: aws.String(TableName),
-		Key: map[string]types.AttributeValue{
-			"uid": &types.AttributeValueMemberS{Value: uid},
-			"sk":  &types.AttributeValueMemberS{Value: sk},
+	_, err = db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					TableName: aws.String(TableName()),
+					Key: map[string]types.AttributeValue{
+						"uid": &types.AttributeValueMemberS{Value: uid},
+						"sk":  &types.AttributeValueMemberS{Value: sk},
+					},
+				},
+			},
+			{
+				Delete: &types.Delete{
+					TableName: aws.String(TableName()),
+					Key: map[string]types.AttributeValue{
+						"uid": &types.AttributeValueMemberS{Value: uid},
+						"sk":  &types.AttributeValueMemberS{Value: searchPrefix + sk},
+					},
+				},
+			},
 		},
 	})
 	return err
@@ -0,0 +1,571 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Lifetimes of the tokens issued for an OAuth grant. Refresh tokens rotate on
+// every use, so the refresh lifetime is how long a client can sit idle.
+const (
+	AccessTokenTTL  = time.Hour
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrTokenNotFound means a presented API key or access token doesn't
+	// exist, has expired, or is malformed, as opposed to the lookup failing.
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrInvalidGrant means a refresh token is unknown, expired, or belongs to
+	// another client.
+	ErrInvalidGrant = errors.New("invalid grant")
+	// ErrTokenReuse means an already-rotated refresh token was presented
+	// again. The whole grant is revoked when this happens, since either the
+	// client or an attacker is holding a stale copy.
+	ErrTokenReuse = errors.New("refresh token reuse detected")
+)
+
+// OAuthGrant is one authorization of an OAuth client by a user. Each grant
+// has at most one live access token and one live refresh token at a time.
+type OAuthGrant struct {
+	GrantID    string `json:"grant_id"`
+	UID        string `json:"-"`
+	ClientID   string `json:"client_id"`
+	Scope      string `json:"scope"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+}
+
+// TokenPair is a freshly issued access and refresh token. The raw values are
+// only available here; the table stores their hashes.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int
+	Scope        string
+}
+
+func grantSK(grantID string) string {
+	return "oauth_grant#" + grantID
+}
+
+func accessLookupPK(hash string) string {
+	return "oauth_access#" + hash
+}
+
+func refreshLookupPK(hash string) string {
+	return "oauth_refresh#" + hash
+}
+
+// newTokenPair generates a token pair for a grant along with the items that
+// store it.
+func newTokenPair(g OAuthGrant, now time.Time) (TokenPair, string, string, []types.TransactWriteItem, error) {
+	access, err := randomHex(32)
+	if err != nil {
+		return TokenPair{}, "", "", nil, fmt.Errorf("generate access token: %w", err)
+	}
+	refresh, err := randomHex(32)
+	if err != nil {
+		return TokenPair{}, "", "", nil, fmt.Errorf("generate refresh token: %w", err)
+	}
+	accessHash, refreshHash := hashKey(access), hashKey(refresh)
+
+	lookup := func(pk string, expires time.Time) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"uid":       &types.AttributeValueMemberS{Value: pk},
+			"sk":        &types.AttributeValueMemberS{Value: pk},
+			"UID":       &types.AttributeValueMemberS{Value: g.UID},
+			"ClientID":  &types.AttributeValueMemberS{Value: g.ClientID},
+			"GrantID":   &types.AttributeValueMemberS{Value: g.GrantID},
+			"Scope":     &types.AttributeValueMemberS{Value: g.Scope},
+			"IssuedAt":  &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+			"ExpiresAt": &types.AttributeValueMemberS{Value: expires.Format(time.RFC3339)},
+			"ttl":       &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", expires.Unix())},
+		}
+	}
+
+	items := []types.TransactWriteItem{
+		{Put: &types.Put{TableName: aws.String(TableName()), Item: lookup(accessLookupPK(accessHash), now.Add(AccessTokenTTL))}},
+		{Put: &types.Put{TableName: aws.String(TableName()), Item: lookup(refreshLookupPK(refreshHash), now.Add(RefreshTokenTTL))}},
+	}
+	pair := TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int(AccessTokenTTL.Seconds()),
+		Scope:        g.Scope,
+	}
+	return pair, accessHash, refreshHash, items, nil
+}
+
+// CreateGrant records a new authorization of clientID by uid and issues its
+// first token pair.
+func CreateGrant(ctx context.Context, uid, clientID, scope string) (TokenPair, error) {
+	grantID, err := randomHex(8)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("generate grant id: %w", err)
+	}
+	return createGrant(ctx, OAuthGrant{GrantID: grantID, UID: uid, ClientID: clientID, Scope: scope})
+}
+
+// CreateGrantForCode records the grant for a redeemed authorization code
+// and issues its first token pair. It returns ErrCodeReuse if the code was
+// replayed in the meantime.
+func CreateGrantForCode(ctx context.Context, ac *AuthCode, scope string) (TokenPair, error) {
+	usedPK := usedCodePK(ac.Code)
+	pair, err := createGrant(ctx, OAuthGrant{GrantID: ac.GrantID, UID: ac.UID, ClientID: ac.ClientID, Scope: scope},
+		types.TransactWriteItem{
+			ConditionCheck: &types.ConditionCheck{
+				TableName: aws.String(TableName()),
+				Key: map[string]types.AttributeValue{
+					"uid": &types.AttributeValueMemberS{Value: usedPK},
+					"sk":  &types.AttributeValueMemberS{Value: usedPK},
+				},
+				ConditionExpression: aws.String("attribute_exists(uid) AND attribute_not_exists(ReplayedAt)"),
+			},
+		},
+	)
+	// The condition check is the last item, so its reason is the last one
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+		aws.ToString(canceled.CancellationReasons[len(canceled.CancellationReasons)-1].Code) == "ConditionalCheckFailed" {
+		return TokenPair{}, ErrCodeReuse
+	}
+	return pair, err
+}
+
+// createGrant writes g with its first token pair, along with any extra items
+// the transaction depends on.
+func createGrant(ctx context.Context, g OAuthGrant, extra ...types.TransactWriteItem) (TokenPair, error) {
+	db, err := client()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	now := time.Now().UTC()
+	pair, accessHash, refreshHash, items, err := newTokenPair(g, now)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	items = append(items, types.TransactWriteItem{
+		Put: &types.Put{
+			TableName: aws.String(TableName()),
+			Item: map[string]types.AttributeValue{
+				"uid":         &types.AttributeValueMemberS{Value: g.UID},
+				"sk":          &types.AttributeValueMemberS{Value: grantSK(g.GrantID)},
+				"ClientID":    &types.AttributeValueMemberS{Value: g.ClientID},
+				"Scope":       &types.AttributeValueMemberS{Value: g.Scope},
+				"CreatedAt":   &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+				"LastUsedAt":  &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+				"AccessHash":  &types.AttributeValueMemberS{Value: accessHash},
+				"RefreshHash": &types.AttributeValueMemberS{Value: refreshHash},
+				// Without a live refresh token the grant is dead, so it
+				// expires along with it
+				"ttl": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Add(RefreshTokenTTL).Unix())},
+			},
+		},
+	})
+	items = append(items, extra...)
+
+	// The client has completed an authorization, so it's no longer at risk
+	// of being cleaned up as unused
+	if err := keepOAuthClient(ctx, db, g.ClientID); err != nil {
+		return TokenPair{}, fmt.Errorf("keep oauth client: %w", err)
+	}
+	if _, err := db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items}); err != nil {
+		return TokenPair{}, fmt.Errorf("write grant: %w", err)
+	}
+	return pair, nil
+}
+
+// tokenRecord is the lookup record behind an access or refresh token.
+type tokenRecord struct {
+	UID        string
+	ClientID   string
+	GrantID    string
+	Scope      string
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	Used       bool
+}
+
+func getTokenRecord(ctx context.Context, db *dynamodb.Client, pk string) (*tokenRecord, error) {
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+
+	rec := &tokenRecord{}
+	if v, ok := out.Item["UID"].(*types.AttributeValueMemberS); ok {
+		rec.UID = v.Value
+	}
+	if v, ok := out.Item["ClientID"].(*types.AttributeValueMemberS); ok {
+		rec.ClientID = v.Value
+	}
+	if v, ok := out.Item["GrantID"].(*types.AttributeValueMemberS); ok {
+		rec.GrantID = v.Value
+	}
+	if v, ok := out.Item["Scope"].(*types.AttributeValueMemberS); ok {
+		rec.Scope = v.Value
+	}
+	if v, ok := out.Item["IssuedAt"].(*types.AttributeValueMemberS); ok {
+		rec.IssuedAt, _ = time.Parse(time.RFC3339, v.Value)
+	}
+	if v, ok := out.Item["ExpiresAt"].(*types.AttributeValueMemberS); ok {
+		rec.ExpiresAt, _ = time.Parse(time.RFC3339, v.Value)
+	}
+	if v, ok := out.Item["LastUsedAt"].(*types.AttributeValueMemberS); ok {
+		rec.LastUsedAt, _ = time.Parse(time.RFC3339, v.Value)
+	}
+	_, rec.Used = out.Item["UsedAt"]
+	return rec, nil
+}
+
+// LookupAccessToken finds the user ID and scope for a raw OAuth access token,
+// and when it expires.
+func LookupAccessToken(ctx context.Context, rawToken string) (uid, scope string, expiresAt time.Time, err error) {
+	db, err := client()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	rec, err := getTokenRecord(ctx, db, accessLookupPK(hashKey(rawToken)))
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("lookup access token: %w", err)
+	}
+	if rec == nil || !time.Now().Before(rec.ExpiresAt) {
+		return "", "", time.Time{}, fmt.Errorf("access token: %w", ErrTokenNotFound)
+	}
+	// The grant was used when this token was issued, if not since
+	lastUsed := rec.IssuedAt
+	if rec.LastUsedAt.After(lastUsed) {
+		lastUsed = rec.LastUsedAt
+	}
+	if time.Since(lastUsed) > APIKeyTouchInterval {
+		touchGrant(ctx, db, rec.UID, rec.GrantID, accessLookupPK(hashKey(rawToken)))
+	}
+	return rec.UID, rec.Scope, rec.ExpiresAt, nil
+}
+
+// touchGrant records that a grant's access token was just used, on both the
+// grant and the token's lookup record, the same way touchAPIKey does for
+// keys. Failures are ignored.
+func touchGrant(ctx context.Context, db *dynamodb.Client, uid, grantID, lookupPK string) {
+	now := time.Now().UTC()
+	_, _ = db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Update: &types.Update{
+					TableName: aws.String(TableName()),
+					Key: map[string]types.AttributeValue{
+						"uid": &types.AttributeValueMemberS{Value: lookupPK},
+						"sk":  &types.AttributeValueMemberS{Value: lookupPK},
+					},
+					UpdateExpression:    aws.String("SET LastUsedAt = :now"),
+					ConditionExpression: aws.String("attribute_exists(sk) AND (attribute_not_exists(LastUsedAt) OR LastUsedAt < :cutoff)"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":now":    &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+						":cutoff": &types.AttributeValueMemberS{Value: now.Add(-APIKeyTouchInterval).Format(time.RFC3339)},
+					},
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName: aws.String(TableName()),
+					Key: map[string]types.AttributeValue{
+						"uid": &types.AttributeValueMemberS{Value: uid},
+						"sk":  &types.AttributeValueMemberS{Value: grantSK(grantID)},
+					},
+					UpdateExpression:    aws.String("SET LastUsedAt = :now"),
+					ConditionExpression: aws.String("attribute_exists(sk)"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":now": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+					},
+				},
+			},
+		},
+	})
+}
+
+// RefreshGrant rotates a grant's tokens: the presented refresh token is marked
+// used, the grant's current access token is deleted, and a new pair is
+// issued. Presenting a used refresh token revokes the grant; the grant is
+// returned along with ErrTokenReuse so the caller can audit it.
+func RefreshGrant(ctx context.Context, rawRefresh, clientID string) (*OAuthGrant, TokenPair, error) {
+	db, err := client()
+	if err != nil {
+		return nil, TokenPair{}, err
+	}
+
+	refreshHash := hashKey(rawRefresh)
+	rec, err := getTokenRecord(ctx, db, refreshLookupPK(refreshHash))
+	if err != nil {
+		return nil, TokenPair{}, fmt.Errorf("lookup refresh token: %w", err)
+	}
+	if rec == nil || !time.Now().Before(rec.ExpiresAt) || (clientID != "" && rec.ClientID != clientID) {
+		return nil, TokenPair{}, ErrInvalidGrant
+	}
+	if rec.Used {
+		if err := RevokeGrant(ctx, rec.UID, rec.GrantID); err != nil {
+			return nil, TokenPair{}, fmt.Errorf("revoke grant after reuse: %w", err)
+		}
+		return &OAuthGrant{GrantID: rec.GrantID, UID: rec.UID, ClientID: rec.ClientID}, TokenPair{}, ErrTokenReuse
+	}
+
+	g, accessHash, err := getGrant(ctx, db, rec.UID, rec.GrantID)
+	if err != nil {
+		return nil, TokenPair{}, err
+	}
+	if g == nil {
+		return nil, TokenPair{}, ErrInvalidGrant
+	}
+	// Deleting a client registration ends its grants
+	c, err := GetOAuthClient(ctx, g.ClientID)
+	if err != nil {
+		return nil, TokenPair{}, fmt.Errorf("get oauth client: %w", err)
+	}
+	if c == nil {
+		return nil, TokenPair{}, ErrInvalidGrant
+	}
+
+	now := time.Now().UTC()
+	pair, newAccessHash, newRefreshHash, items, err := newTokenPair(*g, now)
+	if err != nil {
+		return nil, TokenPair{}, err
+	}
+	refreshPK := refreshLookupPK(refreshHash)
+	accessPK := accessLookupPK(accessHash)
+	items = append(items,
+		types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(TableName()),
+				Key: map[string]types.AttributeValue{
+					"uid": &types.AttributeValueMemberS{Value: refreshPK},
+					"sk":  &types.AttributeValueMemberS{Value: refreshPK},
+				},
+				UpdateExpression:    aws.String("SET UsedAt = :now"),
+				ConditionExpression: aws.String("attribute_exists(uid) AND attribute_not_exists(UsedAt)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":now": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+				},
+			},
+		},
+		types.TransactWriteItem{
+			Delete: &types.Delete{
+				TableName: aws.String(TableName()),
+				Key: map[string]types.AttributeValue{
+					"uid": &types.AttributeValueMemberS{Value: accessPK},
+					"sk":  &types.AttributeValueMemberS{Value: accessPK},
+				},
+			},
+		},
+		types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(TableName()),
+				Key: map[string]types.AttributeValue{
+					"uid": &types.AttributeValueMemberS{Value: g.UID},
+					"sk":  &types.AttributeValueMemberS{Value: grantSK(g.GrantID)},
+				},
+				UpdateExpression:    aws.String("SET AccessHash = :access, RefreshHash = :refresh, LastUsedAt = :now, #ttl = :ttl"),
+				ConditionExpression: aws.String("RefreshHash = :old"),
+				ExpressionAttributeNames: map[string]string{
+					"#ttl": "ttl",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":access":  &types.AttributeValueMemberS{Value: newAccessHash},
+					":refresh": &types.AttributeValueMemberS{Value: newRefreshHash},
+					":now":     &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+					":ttl":     &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Add(RefreshTokenTTL).Unix())},
+					":old":     &types.AttributeValueMemberS{Value: refreshHash},
+				},
+			},
+		},
+	)
+
+	_, err = db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		// Another request rotated this token first
+		return nil, TokenPair{}, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, TokenPair{}, fmt.Errorf("rotate tokens: %w", err)
+	}
+	tokenRevoked(accessHash)
+	return g, pair, nil
+}
+
+func getGrant(ctx context.Context, db *dynamodb.Client, uid, grantID string) (*OAuthGrant, string, error) {
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: uid},
+			"sk":  &types.AttributeValueMemberS{Value: grantSK(grantID)},
+		},
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("get grant: %w", err)
+	}
+	if out.Item == nil {
+		return nil, "", nil
+	}
+
+	g := &OAuthGrant{GrantID: grantID, UID: uid}
+	var accessHash string
+	if v, ok := out.Item["ClientID"].(*types.AttributeValueMemberS); ok {
+		g.ClientID = v.Value
+	}
+	if v, ok := out.Item["Scope"].(*types.AttributeValueMemberS); ok {
+		g.Scope = v.Value
+	}
+	if v, ok := out.Item["CreatedAt"].(*types.AttributeValueMemberS); ok {
+		g.CreatedAt = v.Value
+	}
+	if v, ok := out.Item["LastUsedAt"].(*types.AttributeValueMemberS); ok {
+		g.LastUsedAt = v.Value
+	}
+	if v, ok := out.Item["AccessHash"].(*types.AttributeValueMemberS); ok {
+		accessHash = v.Value
+	}
+	return g, accessHash, nil
+}
+
+// RevokeGrant deletes a grant along with its live access and refresh tokens.
+// Used refresh tokens are left to expire so later reuse is still detected.
+func RevokeGrant(ctx context.Context, uid, grantID string) error {
+	db, err := client()
+	if err != nil {
+		return err
+	}
+
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: uid},
+			"sk":  &types.AttributeValueMemberS{Value: grantSK(grantID)},
+		},
+		ProjectionExpression: aws.String("AccessHash, RefreshHash"),
+	})
+	if err != nil {
+		return fmt.Errorf("get grant for revoke: %w", err)
+	}
+	if out.Item == nil {
+		return nil
+	}
+
+	del := func(uid, sk string) types.TransactWriteItem {
+		return types.TransactWriteItem{
+			Delete: &types.Delete{
+				TableName: aws.String(TableName()),
+				Key: map[string]types.AttributeValue{
+					"uid": &types.AttributeValueMemberS{Value: uid},
+					"sk":  &types.AttributeValueMemberS{Value: sk},
+				},
+			},
+		}
+	}
+	items := []types.TransactWriteItem{del(uid, grantSK(grantID))}
+	var accessHash string
+	if v, ok := out.Item["AccessHash"].(*types.AttributeValueMemberS); ok {
+		accessHash = v.Value
+		items = append(items, del(accessLookupPK(v.Value), accessLookupPK(v.Value)))
+	}
+	if v, ok := out.Item["RefreshHash"].(*types.AttributeValueMemberS); ok {
+		items = append(items, del(refreshLookupPK(v.Value), refreshLookupPK(v.Value)))
+	}
+
+	if _, err := db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items}); err != nil {
+		return fmt.Errorf("revoke grant: %w", err)
+	}
+	if accessHash != "" {
+		tokenRevoked(accessHash)
+	}
+	return nil
+}
+
+// ListGrants returns a user's OAuth grants that still have a live refresh
+// token. DynamoDB TTL deletes dead ones, but not right away.
+func ListGrants(ctx context.Context, uid string) ([]OAuthGrant, error) {
+	db, err := client()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var grants []OAuthGrant
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := db.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(TableName()),
+			KeyConditionExpression: aws.String("uid = :uid AND begins_with(sk, :prefix)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":uid":    &types.AttributeValueMemberS{Value: uid},
+				":prefix": &types.AttributeValueMemberS{Value: grantSK("")},
+			},
+			ProjectionExpression:     aws.String("sk, ClientID, Scope, CreatedAt, LastUsedAt, #ttl"),
+			ExpressionAttributeNames: map[string]string{"#ttl": "ttl"},
+			ExclusiveStartKey:        startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list grants: %w", err)
+		}
+
+		for _, item := range out.Items {
+			sk := item["sk"].(*types.AttributeValueMemberS).Value
+			g := OAuthGrant{GrantID: sk[len(grantSK("")):], UID: uid}
+			if v, ok := item["ClientID"].(*types.AttributeValueMemberS); ok {
+				g.ClientID = v.Value
+			}
+			if v, ok := item["Scope"].(*types.AttributeValueMemberS); ok {
+				g.Scope = v.Value
+			}
+			if v, ok := item["CreatedAt"].(*types.AttributeValueMemberS); ok {
+				g.CreatedAt = v.Value
+			}
+			if v, ok := item["LastUsedAt"].(*types.AttributeValueMemberS); ok {
+				g.LastUsedAt = v.Value
+			}
+			if grantExpired(item, now) {
+				continue
+			}
+			grants = append(grants, g)
+		}
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+	return grants, nil
+}
+
+// grantExpired reports whether a grant item's refresh token has expired.
+// Grants written before they had a ttl expire RefreshTokenTTL after they
+// were last used.
+func grantExpired(item map[string]types.AttributeValue, now time.Time) bool {
+	if v, ok := item["ttl"].(*types.AttributeValueMemberN); ok {
+		ttl, err := strconv.ParseInt(v.Value, 10, 64)
+		return err == nil && now.Unix() >= ttl
+	}
+	if v, ok := item["LastUsedAt"].(*types.AttributeValueMemberS); ok {
+		lastUsed, err := time.Parse(time.RFC3339, v.Value)
+		return err == nil && now.Sub(lastUsed) >= RefreshTokenTTL
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+func identityLookupPK(provider, subject string) string {
+	return "identity#" + provider + "#" + subject
+}
+
+// ResolveIdentity returns the user ID for an account at an external identity
+// provider, creating a user the first time the account signs in. A lookup
+// record maps the account to the user, and a record under the user lists
+// the account. Cognito users don't go through this: their sub is their
+// user ID.
+func ResolveIdentity(ctx context.Context, provider, subject, email string) (string, error) {
+	db, err := client()
+	if err != nil {
+		return "", err
+	}
+
+	pk := identityLookupPK(provider, subject)
+	if uid, err := getIdentity(ctx, db, pk); err != nil || uid != "" {
+		return uid, err
+	}
+
+	uid := uuid.New().String()
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err = db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{
+				TableName: aws.String(TableName()),
+				Item: map[string]types.AttributeValue{
+					"uid":       &types.AttributeValueMemberS{Value: pk},
+					"sk":        &types.AttributeValueMemberS{Value: pk},
+					"UID":       &types.AttributeValueMemberS{Value: uid},
+					"CreatedAt": &types.AttributeValueMemberS{Value: now},
+				},
+				ConditionExpression: aws.String("attribute_not_exists(uid)"),
+			}},
+			{Put: &types.Put{
+				TableName: aws.String(TableName()),
+				Item: map[string]types.AttributeValue{
+					"uid":       &types.AttributeValueMemberS{Value: uid},
+					"sk":        &types.AttributeValueMemberS{Value: pk},
+					"Provider":  &types.AttributeValueMemberS{Value: provider},
+					"Subject":   &types.AttributeValueMemberS{Value: subject},
+					"Email":     &types.AttributeValueMemberS{Value: email},
+					"CreatedAt": &types.AttributeValueMemberS{Value: now},
+				},
+			}},
+		},
+	})
+	// The lookup record's condition is the first item, so its reason is the
+	// first one. Any other cancellation, like a conflict, is just an error.
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+		aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+		// A concurrent first sign-in created the user; use theirs
+		theirs, err := getIdentity(ctx, db, pk)
+		if err == nil && theirs == "" {
+			err = errors.New("identity created concurrently but not found")
+		}
+		return theirs, err
+	}
+	if err != nil {
+		return "", fmt.Errorf("create identity: %w", err)
+	}
+	return uid, nil
+}
+
+// getIdentity returns the user ID in an identity lookup record, or "" if
+// there isn't one.
+func getIdentity(ctx context.Context, db *dynamodb.Client, pk string) (string, error) {
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("get identity: %w", err)
+	}
+	if v, ok := out.Item["UID"].(*types.AttributeValueMemberS); ok {
+		return v.Value, nil
+	}
+	return "", nil
+}
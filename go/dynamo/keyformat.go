@@ -0,0 +1,78 @@
+package dynamo
+
+import (
+	"crypto/rand"
+	"hash/crc32"
+	"math/big"
+	"strings"
+)
+
+// API keys look like jl_live_<key id>_<secret><checksum>: the 8-hex-char key
+// ID, 40 base62 characters of secret, and a 6-character base62 CRC32 of
+// everything before it. The prefix lets secret scanners recognize leaked
+// keys, and the checksum lets typos be rejected without a table lookup. Keys
+// created before this format are 64 bare hex characters and still work.
+const (
+	APIKeyPrefix    = "jl_live_"
+	keyIDLen        = 8
+	keySecretLen    = 40
+	keyChecksumLen  = 6
+	formattedKeyLen = len(APIKeyPrefix) + keyIDLen + 1 + keySecretLen + keyChecksumLen
+)
+
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+func randomBase62(n int) (string, error) {
+	size := big.NewInt(int64(len(base62)))
+	b := make([]byte, n)
+	for i := range b {
+		v, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		b[i] = base62[v.Int64()]
+	}
+	return string(b), nil
+}
+
+func keyChecksum(body string) string {
+	sum := crc32.ChecksumIEEE([]byte(body))
+	b := make([]byte, keyChecksumLen)
+	for i := keyChecksumLen - 1; i >= 0; i-- {
+		b[i] = base62[sum%62]
+		sum /= 62
+	}
+	return string(b)
+}
+
+// formatAPIKey generates a new raw key for a key ID.
+func formatAPIKey(keyID string) (string, error) {
+	secret, err := randomBase62(keySecretLen)
+	if err != nil {
+		return "", err
+	}
+	body := APIKeyPrefix + keyID + "_" + secret
+	return body + keyChecksum(body), nil
+}
+
+// ParseAPIKey checks a prefixed API key's shape and checksum and returns the
+// key ID embedded in it. It doesn't touch the table.
+func ParseAPIKey(raw string) (keyID string, ok bool) {
+	if len(raw) != formattedKeyLen || !strings.HasPrefix(raw, APIKeyPrefix) {
+		return "", false
+	}
+	rest := raw[len(APIKeyPrefix):]
+	keyID, rest = rest[:keyIDLen], rest[keyIDLen:]
+	if rest[0] != '_' || strings.Trim(keyID, "0123456789abcdef") != "" {
+		return "", false
+	}
+	secret := rest[1 : 1+keySecretLen]
+	if strings.Trim(secret, base62) != "" {
+		return "", false
+	}
+	body := raw[:len(raw)-keyChecksumLen]
+	if keyChecksum(body) != raw[len(body):] {
+		return "", false
+	}
+	return keyID, true
+}
@@ -2,6 +2,8 @@ package dynamo
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"time"
 
@@ -10,15 +12,49 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
-// OAuthClient represents a dynamically registered OAuth client.
+// OAuthClient represents a dynamically registered OAuth client. The JSON
+// names are the RFC 7591 metadata names.
 type OAuthClient struct {
-	ClientID     string   `json:"client_id"`
-	ClientName   string   `json:"client_name"`
-	RedirectURIs []string `json:"redirect_uris"`
-	GrantTypes   []string `json:"grant_types"`
-	CreatedAt    string   `json:"created_at"`
+	ClientID                string   `json:"client_id"`
+	ClientName              string   `json:"client_name"`
+	RedirectURIs            []string `json:"redirect_uris"`
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+	ClientURI               string   `json:"client_uri,omitempty"`
+	LogoURI                 string   `json:"logo_uri,omitempty"`
+	TOSURI                  string   `json:"tos_uri,omitempty"`
+	PolicyURI               string   `json:"policy_uri,omitempty"`
+	Contacts                []string `json:"contacts,omitempty"`
+	SoftwareID              string   `json:"software_id,omitempty"`
+	SoftwareVersion         string   `json:"software_version,omitempty"`
+	CreatedAt               string   `json:"created_at"`
+
+	// ExpiresAt is when the client is deleted if it still hasn't completed
+	// an authorization. It's zero once it has.
+	ExpiresAt time.Time `json:"-"`
+	// RegistrationHash is the hash of the RFC 7592 registration access
+	// token. Clients registered before management existed don't have one.
+	RegistrationHash string `json:"-"`
 }
 
+// Lifetimes of the records behind the authorization code flow. Expired
+// records can linger until DynamoDB's TTL sweeper gets to them, so reads
+// check ExpiresAt themselves.
+const (
+	AuthSessionTTL = 10 * time.Minute
+	AuthCodeTTL    = 5 * time.Minute
+	// usedCodeTTL is how long a redeemed code is remembered, so replaying
+	// it still revokes what it was exchanged for.
+	usedCodeTTL = 24 * time.Hour
+)
+
+// ErrCodeReuse means an authorization code was presented after it had
+// already been redeemed. The grant issued for it is revoked when this
+// happens, since the code has leaked.
+var ErrCodeReuse = errors.New("authorization code reuse detected")
+
 // AuthSession stores state during the OAuth authorize flow.
 type AuthSession struct {
 	SessionID     string
@@ -26,19 +62,35 @@ type AuthSession struct {
 	RedirectURI   string
 	CodeChallenge string
 	State         string
-	CreatedAt     string
+	Scope         string
+	// Provider is the identity provider the user signs in through. Empty
+	// means Cognito.
+	Provider string
+	// UserCode is set when the session approves a device authorization
+	// rather than issuing a code to a redirect URI.
+	UserCode  string
+	CreatedAt string
+	ExpiresAt time.Time
+
+	// Set once the user has signed in and is being asked for consent.
+	UID         string
+	ConsentHash string
 }
 
 // AuthCode stores a generated authorization code pending exchange.
 type AuthCode struct {
-	Code                string
-	SessionID           string
-	UID                 string
-	CognitoAccessToken  string
-	CognitoRefreshToken string
-	CodeChallenge       string
-	ClientID            string
-	RedirectURI         string
+	Code          string
+	SessionID     string
+	UID           string
+	CodeChallenge string
+	ClientID      string
+	RedirectURI   string
+	Scope         string
+	ExpiresAt     time.Time
+
+	// GrantID is set when the code is redeemed, and is the ID the grant
+	// issued for it has to use.
+	GrantID string
 }
 
 // PutOAuthClient stores a new OAuth client registration.
@@ -55,21 +107,46 @@ func PutOAuthClient(ctx context.Context, c OAuthClient) error {
 		"ClientName": &types.AttributeValueMemberS{Value: c.ClientName},
 		"CreatedAt":  &types.AttributeValueMemberS{Value: c.CreatedAt},
 	}
-	if len(c.RedirectURIs) > 0 {
-		item["RedirectURIs"] = &types.AttributeValueMemberSS{Value: c.RedirectURIs}
-	}
-	if len(c.GrantTypes) > 0 {
-		item["GrantTypes"] = &types.AttributeValueMemberSS{Value: c.GrantTypes}
+	for name, v := range map[string]string{
+		"TokenEndpointAuthMethod": c.TokenEndpointAuthMethod,
+		"Scope":                   c.Scope,
+		"ClientURI":               c.ClientURI,
+		"LogoURI":                 c.LogoURI,
+		"TOSURI":                  c.TOSURI,
+		"PolicyURI":               c.PolicyURI,
+		"SoftwareID":              c.SoftwareID,
+		"SoftwareVersion":         c.SoftwareVersion,
+		"RegistrationHash":        c.RegistrationHash,
+	} {
+		if v != "" {
+			item[name] = &types.AttributeValueMemberS{Value: v}
+		}
+	}
+	for name, v := range map[string][]string{
+		"RedirectURIs":  c.RedirectURIs,
+		"GrantTypes":    c.GrantTypes,
+		"ResponseTypes": c.ResponseTypes,
+		"Contacts":      c.Contacts,
+	} {
+		if len(v) > 0 {
+			item[name] = &types.AttributeValueMemberSS{Value: v}
+		}
+	}
+	if !c.ExpiresAt.IsZero() {
+		item["ExpiresAt"] = &types.AttributeValueMemberS{Value: c.ExpiresAt.UTC().Format(time.RFC3339)}
+		item["ttl"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", c.ExpiresAt.Unix())}
 	}
 
 	_, err = db.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(TableName()),
 		Item:      item,
 	})
 	return err
 }
 
-// GetOAuthClient retrieves an OAuth client by ID.
+// GetOAuthClient retrieves an OAuth client by client_id. It returns nil for
+// unknown clients and for unused ones past their expiry that TTL hasn't
+// removed yet.
 func GetOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error) {
 	db, err := client()
 	if err != nil {
@@ -78,7 +155,7 @@ func GetOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error)
 
 	pk := "oauth_client#" + clientID
 	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(TableName()),
 		Key: map[string]types.AttributeValue{
 			"uid": &types.AttributeValueMemberS{Value: pk},
 			"sk":  &types.AttributeValueMemberS{Value: pk},
@@ -92,22 +169,109 @@ func GetOAuthClient(ctx context.Context, clientID string) (*OAuthClient, error)
 	}
 
 	c := &OAuthClient{ClientID: clientID}
-	if v, ok := out.Item["ClientName"].(*types.AttributeValueMemberS); ok {
-		c.ClientName = v.Value
+	for name, dst := range map[string]*string{
+		"ClientName":              &c.ClientName,
+		"TokenEndpointAuthMethod": &c.TokenEndpointAuthMethod,
+		"Scope":                   &c.Scope,
+		"ClientURI":               &c.ClientURI,
+		"LogoURI":                 &c.LogoURI,
+		"TOSURI":                  &c.TOSURI,
+		"PolicyURI":               &c.PolicyURI,
+		"SoftwareID":              &c.SoftwareID,
+		"SoftwareVersion":         &c.SoftwareVersion,
+		"CreatedAt":               &c.CreatedAt,
+		"RegistrationHash":        &c.RegistrationHash,
+	} {
+		if v, ok := out.Item[name].(*types.AttributeValueMemberS); ok {
+			*dst = v.Value
+		}
+	}
+	for name, dst := range map[string]*[]string{
+		"RedirectURIs":  &c.RedirectURIs,
+		"GrantTypes":    &c.GrantTypes,
+		"ResponseTypes": &c.ResponseTypes,
+		"Contacts":      &c.Contacts,
+	} {
+		if v, ok := out.Item[name].(*types.AttributeValueMemberSS); ok {
+			*dst = v.Value
+		}
+	}
+	if v, ok := out.Item["ExpiresAt"].(*types.AttributeValueMemberS); ok {
+		c.ExpiresAt, _ = time.Parse(time.RFC3339, v.Value)
+		if time.Now().After(c.ExpiresAt) {
+			return nil, nil
+		}
 	}
-	if v, ok := out.Item["RedirectURIs"].(*types.AttributeValueMemberSS); ok {
-		c.RedirectURIs = v.Value
+	return c, nil
+}
+
+// NewRegistrationToken generates an RFC 7592 registration access token for
+// managing the client and sets its hash on the client. Store the client
+// afterwards.
+func (c *OAuthClient) NewRegistrationToken() (string, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", fmt.Errorf("generate registration token: %w", err)
 	}
-	if v, ok := out.Item["GrantTypes"].(*types.AttributeValueMemberSS); ok {
-		c.GrantTypes = v.Value
+	c.RegistrationHash = hashKey(token)
+	return token, nil
+}
+
+// RegistrationTokenValid reports whether token is the client's registration
+// access token.
+func (c *OAuthClient) RegistrationTokenValid(token string) bool {
+	if c.RegistrationHash == "" || token == "" {
+		return false
 	}
-	if v, ok := out.Item["CreatedAt"].(*types.AttributeValueMemberS); ok {
-		c.CreatedAt = v.Value
+	return subtle.ConstantTimeCompare([]byte(hashKey(token)), []byte(c.RegistrationHash)) == 1
+}
+
+// DeleteOAuthClient removes a client registration. Grants already issued
+// to it stop refreshing, since the token endpoint checks the client.
+func DeleteOAuthClient(ctx context.Context, clientID string) error {
+	db, err := client()
+	if err != nil {
+		return err
 	}
-	return c, nil
+
+	pk := "oauth_client#" + clientID
+	_, err = db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("delete oauth client: %w", err)
+	}
+	return nil
 }
 
-// PutAuthSession stores an OAuth authorization session with a 10-minute TTL.
+// keepOAuthClient clears an unused client's expiry once it has completed an
+// authorization.
+func keepOAuthClient(ctx context.Context, db *dynamodb.Client, clientID string) error {
+	pk := "oauth_client#" + clientID
+	_, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+		UpdateExpression:    aws.String("REMOVE ExpiresAt, #ttl"),
+		ConditionExpression: aws.String("attribute_exists(ExpiresAt)"),
+		ExpressionAttributeNames: map[string]string{
+			"#ttl": "ttl",
+		},
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return nil
+	}
+	return err
+}
+
+// PutAuthSession stores an OAuth authorization session for AuthSessionTTL.
 func PutAuthSession(ctx context.Context, s AuthSession) error {
 	db, err := client()
 	if err != nil {
@@ -115,10 +279,10 @@ func PutAuthSession(ctx context.Context, s AuthSession) error {
 	}
 
 	pk := "oauth_session#" + s.SessionID
-	ttl := time.Now().Add(10 * time.Minute).Unix()
+	expires := time.Now().UTC().Add(AuthSessionTTL)
 
 	_, err = db.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(TableName()),
 		Item: map[string]types.AttributeValue{
 			"uid":           &types.AttributeValueMemberS{Value: pk},
 			"sk":            &types.AttributeValueMemberS{Value: pk},
@@ -126,14 +290,19 @@ func PutAuthSession(ctx context.Context, s AuthSession) error {
 			"RedirectURI":   &types.AttributeValueMemberS{Value: s.RedirectURI},
 			"CodeChallenge": &types.AttributeValueMemberS{Value: s.CodeChallenge},
 			"State":         &types.AttributeValueMemberS{Value: s.State},
+			"Scope":         &types.AttributeValueMemberS{Value: s.Scope},
+			"Provider":      &types.AttributeValueMemberS{Value: s.Provider},
+			"UserCode":      &types.AttributeValueMemberS{Value: s.UserCode},
 			"CreatedAt":     &types.AttributeValueMemberS{Value: s.CreatedAt},
-			"ttl":           &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", ttl)},
+			"ExpiresAt":     &types.AttributeValueMemberS{Value: expires.Format(time.RFC3339)},
+			"ttl":           &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", expires.Unix())},
 		},
 	})
 	return err
 }
 
-// GetAuthSession retrieves an OAuth authorization session.
+// GetAuthSession retrieves an OAuth authorization session. It returns nil
+// for unknown or expired sessions.
 func GetAuthSession(ctx context.Context, sessionID string) (*AuthSession, error) {
 	db, err := client()
 	if err != nil {
@@ -142,7 +311,7 @@ func GetAuthSession(ctx context.Context, sessionID string) (*AuthSession, error)
 
 	pk := "oauth_session#" + sessionID
 	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(TableName()),
 		Key: map[string]types.AttributeValue{
 			"uid": &types.AttributeValueMemberS{Value: pk},
 			"sk":  &types.AttributeValueMemberS{Value: pk},
@@ -155,26 +324,124 @@ func GetAuthSession(ctx context.Context, sessionID string) (*AuthSession, error)
 		return nil, nil
 	}
 
+	s := authSessionFromItem(sessionID, out.Item)
+	if !time.Now().Before(s.ExpiresAt) {
+		return nil, nil
+	}
+	return s, nil
+}
+
+func authSessionFromItem(sessionID string, item map[string]types.AttributeValue) *AuthSession {
 	s := &AuthSession{SessionID: sessionID}
-	if v, ok := out.Item["ClientID"].(*types.AttributeValueMemberS); ok {
+	if v, ok := item["ClientID"].(*types.AttributeValueMemberS); ok {
 		s.ClientID = v.Value
 	}
-	if v, ok := out.Item["RedirectURI"].(*types.AttributeValueMemberS); ok {
+	if v, ok := item["RedirectURI"].(*types.AttributeValueMemberS); ok {
 		s.RedirectURI = v.Value
 	}
-	if v, ok := out.Item["CodeChallenge"].(*types.AttributeValueMemberS); ok {
+	if v, ok := item["CodeChallenge"].(*types.AttributeValueMemberS); ok {
 		s.CodeChallenge = v.Value
 	}
-	if v, ok := out.Item["State"].(*types.AttributeValueMemberS); ok {
+	if v, ok := item["State"].(*types.AttributeValueMemberS); ok {
 		s.State = v.Value
 	}
-	if v, ok := out.Item["CreatedAt"].(*types.AttributeValueMemberS); ok {
+	if v, ok := item["Scope"].(*types.AttributeValueMemberS); ok {
+		s.Scope = v.Value
+	}
+	if v, ok := item["Provider"].(*types.AttributeValueMemberS); ok {
+		s.Provider = v.Value
+	}
+	if v, ok := item["UserCode"].(*types.AttributeValueMemberS); ok {
+		s.UserCode = v.Value
+	}
+	if v, ok := item["CreatedAt"].(*types.AttributeValueMemberS); ok {
 		s.CreatedAt = v.Value
 	}
-	return s, nil
+	if v, ok := item["ExpiresAt"].(*types.AttributeValueMemberS); ok {
+		s.ExpiresAt, _ = time.Parse(time.RFC3339, v.Value)
+	}
+	if v, ok := item["UID"].(*types.AttributeValueMemberS); ok {
+		s.UID = v.Value
+	}
+	if v, ok := item["ConsentHash"].(*types.AttributeValueMemberS); ok {
+		s.ConsentHash = v.Value
+	}
+	return s
 }
 
-// PutAuthCode stores an authorization code with a 5-minute TTL.
+// StartConsent records who signed in on an auth session and returns a
+// one-time token the consent form has to post back, so only the browser
+// that signed in can answer it.
+func StartConsent(ctx context.Context, s AuthSession) (string, error) {
+	db, err := client()
+	if err != nil {
+		return "", err
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return "", fmt.Errorf("generate consent token: %w", err)
+	}
+	pk := "oauth_session#" + s.SessionID
+	_, err = db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+		UpdateExpression:    aws.String("SET UID = :uid, ConsentHash = :hash"),
+		ConditionExpression: aws.String("ExpiresAt > :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":  &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+			":uid":  &types.AttributeValueMemberS{Value: s.UID},
+			":hash": &types.AttributeValueMemberS{Value: hashKey(token)},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("start consent: %w", err)
+	}
+	return token, nil
+}
+
+// FinishConsent checks a consent form's token against its auth session and
+// deletes the session, so the form can only be answered once. It returns
+// the session, or nil if the token is wrong or already used or the session
+// has expired.
+func FinishConsent(ctx context.Context, sessionID, token string) (*AuthSession, error) {
+	db, err := client()
+	if err != nil {
+		return nil, err
+	}
+
+	pk := "oauth_session#" + sessionID
+	out, err := db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+		ConditionExpression: aws.String("ConsentHash = :hash AND ExpiresAt > :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":hash": &types.AttributeValueMemberS{Value: hashKey(token)},
+			":now":  &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("finish consent: %w", err)
+	}
+	return authSessionFromItem(sessionID, out.Attributes), nil
+}
+
+func usedCodePK(code string) string {
+	return "oauth_code_used#" + hashKey(code)
+}
+
+// PutAuthCode stores an authorization code for AuthCodeTTL.
 func PutAuthCode(ctx context.Context, ac AuthCode) error {
 	db, err := client()
 	if err != nil {
@@ -182,28 +449,33 @@ func PutAuthCode(ctx context.Context, ac AuthCode) error {
 	}
 
 	pk := "oauth_code#" + ac.Code
-	ttl := time.Now().Add(5 * time.Minute).Unix()
+	expires := time.Now().UTC().Add(AuthCodeTTL)
 
 	_, err = db.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(TableName()),
 		Item: map[string]types.AttributeValue{
-			"uid":                 &types.AttributeValueMemberS{Value: pk},
-			"sk":                  &types.AttributeValueMemberS{Value: pk},
-			"SessionID":           &types.AttributeValueMemberS{Value: ac.SessionID},
-			"UID":                 &types.AttributeValueMemberS{Value: ac.UID},
-			"CognitoAccessToken":  &types.AttributeValueMemberS{Value: ac.CognitoAccessToken},
-			"CognitoRefreshToken": &types.AttributeValueMemberS{Value: ac.CognitoRefreshToken},
-			"CodeChallenge":       &types.AttributeValueMemberS{Value: ac.CodeChallenge},
-			"ClientID":            &types.AttributeValueMemberS{Value: ac.ClientID},
-			"RedirectURI":         &types.AttributeValueMemberS{Value: ac.RedirectURI},
-			"ttl":                 &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", ttl)},
+			"uid":           &types.AttributeValueMemberS{Value: pk},
+			"sk":            &types.AttributeValueMemberS{Value: pk},
+			"SessionID":     &types.AttributeValueMemberS{Value: ac.SessionID},
+			"UID":           &types.AttributeValueMemberS{Value: ac.UID},
+			"CodeChallenge": &types.AttributeValueMemberS{Value: ac.CodeChallenge},
+			"ClientID":      &types.AttributeValueMemberS{Value: ac.ClientID},
+			"RedirectURI":   &types.AttributeValueMemberS{Value: ac.RedirectURI},
+			"Scope":         &types.AttributeValueMemberS{Value: ac.Scope},
+			"ExpiresAt":     &types.AttributeValueMemberS{Value: expires.Format(time.RFC3339)},
+			"ttl":           &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", expires.Unix())},
 		},
 	})
 	return err
 }
 
-// GetAuthCode retrieves and deletes an authorization code (one-time use).
-func GetAuthCode(ctx context.Context, code string) (*AuthCode, error) {
+// RedeemAuthCode consumes an authorization code. The code is deleted, on
+// the condition that it hasn't expired, in the same transaction that
+// remembers it as redeemed along with the grant ID it's given, so of two
+// concurrent exchanges only one gets it and there's no moment when the code
+// is gone but not yet remembered. Presenting it again revokes that grant
+// and returns ErrCodeReuse. It returns nil for unknown or expired codes.
+func RedeemAuthCode(ctx context.Context, code string) (*AuthCode, error) {
 	db, err := client()
 	if err != nil {
 		return nil, err
@@ -214,45 +486,119 @@ func GetAuthCode(ctx context.Context, code string) (*AuthCode, error) {
 		"uid": &types.AttributeValueMemberS{Value: pk},
 		"sk":  &types.AttributeValueMemberS{Value: pk},
 	}
-
 	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(TableName),
-		Key:       key,
+		TableName:      aws.String(TableName()),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
 	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("get auth code: %w", err)
 	}
 	if out.Item == nil {
-		return nil, nil
+		return nil, authCodeReplayed(ctx, db, code)
 	}
 
-	// Delete immediately (one-time use)
-	_, _ = db.DeleteItem(ctx, &dynamodb.DeleteItemInput{
-		TableName: aws.String(TableName),
-		Key:       key,
-	})
-
 	ac := &AuthCode{Code: code}
-	if v, ok := out.Item["SessionID"].(*types.AttributeValueMemberS); ok {
-		ac.SessionID = v.Value
-	}
-	if v, ok := out.Item["UID"].(*types.AttributeValueMemberS); ok {
-		ac.UID = v.Value
+	for name, dst := range map[string]*string{
+		"SessionID":     &ac.SessionID,
+		"UID":           &ac.UID,
+		"CodeChallenge": &ac.CodeChallenge,
+		"ClientID":      &ac.ClientID,
+		"RedirectURI":   &ac.RedirectURI,
+		"Scope":         &ac.Scope,
+	} {
+		if v, ok := out.Item[name].(*types.AttributeValueMemberS); ok {
+			*dst = v.Value
+		}
+	}
+	if v, ok := out.Item["ExpiresAt"].(*types.AttributeValueMemberS); ok {
+		ac.ExpiresAt, _ = time.Parse(time.RFC3339, v.Value)
 	}
-	if v, ok := out.Item["CognitoAccessToken"].(*types.AttributeValueMemberS); ok {
-		ac.CognitoAccessToken = v.Value
-	}
-	if v, ok := out.Item["CognitoRefreshToken"].(*types.AttributeValueMemberS); ok {
-		ac.CognitoRefreshToken = v.Value
+
+	if ac.GrantID, err = randomHex(8); err != nil {
+		return nil, fmt.Errorf("generate grant id: %w", err)
+	}
+	now := time.Now().UTC()
+	usedPK := usedCodePK(code)
+	_, err = db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					TableName:           aws.String(TableName()),
+					Key:                 key,
+					ConditionExpression: aws.String("ExpiresAt > :now"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":now": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+					},
+				},
+			},
+			{
+				Put: &types.Put{
+					TableName: aws.String(TableName()),
+					Item: map[string]types.AttributeValue{
+						"uid":      &types.AttributeValueMemberS{Value: usedPK},
+						"sk":       &types.AttributeValueMemberS{Value: usedPK},
+						"UID":      &types.AttributeValueMemberS{Value: ac.UID},
+						"ClientID": &types.AttributeValueMemberS{Value: ac.ClientID},
+						"GrantID":  &types.AttributeValueMemberS{Value: ac.GrantID},
+						"UsedAt":   &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+						"ttl":      &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", now.Add(usedCodeTTL).Unix())},
+					},
+					ConditionExpression: aws.String("attribute_not_exists(uid)"),
+				},
+			},
+		},
+	})
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		// Expired, or another exchange redeemed it first
+		return nil, authCodeReplayed(ctx, db, code)
 	}
-	if v, ok := out.Item["CodeChallenge"].(*types.AttributeValueMemberS); ok {
-		ac.CodeChallenge = v.Value
+	if err != nil {
+		return nil, fmt.Errorf("redeem auth code: %w", err)
 	}
-	if v, ok := out.Item["ClientID"].(*types.AttributeValueMemberS); ok {
-		ac.ClientID = v.Value
+	return ac, nil
+}
+
+// authCodeReplayed handles a code that couldn't be redeemed. If it was
+// redeemed before, it's marked as replayed, which stops a grant from still
+// being issued for it, and the grant already issued is revoked.
+func authCodeReplayed(ctx context.Context, db *dynamodb.Client, code string) error {
+	pk := usedCodePK(code)
+	out, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+		UpdateExpression:    aws.String("SET ReplayedAt = :now"),
+		ConditionExpression: aws.String("attribute_exists(uid)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		// Never redeemed: unknown or expired
+		return nil
 	}
-	if v, ok := out.Item["RedirectURI"].(*types.AttributeValueMemberS); ok {
-		ac.RedirectURI = v.Value
+	if err != nil {
+		return fmt.Errorf("mark auth code replayed: %w", err)
 	}
-	return ac, nil
+
+	uid, _ := out.Attributes["UID"].(*types.AttributeValueMemberS)
+	grantID, _ := out.Attributes["GrantID"].(*types.AttributeValueMemberS)
+	if uid != nil && grantID != nil {
+		if err := RevokeGrant(ctx, uid.Value, grantID.Value); err != nil {
+			return fmt.Errorf("revoke grant after code reuse: %w", err)
+		}
+		e := AuditEvent{Type: AuditCodeReuse, Detail: "grant revoked"}
+		if v, ok := out.Attributes["ClientID"].(*types.AttributeValueMemberS); ok {
+			e.ClientID = v.Value
+		}
+		// As with refresh token reuse, the revocation is what matters
+		_ = RecordAudit(ctx, uid.Value, e)
+	}
+	return ErrCodeReuse
 }
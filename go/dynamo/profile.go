@@ -99,7 +99,7 @@ func GetProfile(ctx context.Context, uid string) (Profile, error) {
 	}
 
 	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(TableName()),
 		Key: map[string]types.AttributeValue{
 			"uid": &types.AttributeValueMemberS{Value: uid},
 			"sk":  &types.AttributeValueMemberS{Value: "profile"},
@@ -172,7 +172,7 @@ func UpdateProfile(ctx context.Context, uid string, fields map[string]string) er
 	}
 
 	_, err = db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(TableName()),
 		Key: map[string]types.AttributeValue{
 			"uid": &types.AttributeValueMemberS{Value: uid},
 			"sk":  &types.AttributeValueMemberS{Value: "profile"},
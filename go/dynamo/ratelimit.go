@@ -0,0 +1,137 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// CountHit counts one request against key in the current fixed window and
+// returns how many the window has seen, including this one, and when the
+// window ends. Counters expire through TTL once their window is over.
+func CountHit(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	db, err := client()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	start := time.Now().Truncate(window)
+	end := start.Add(window)
+	pk := "ratelimit#" + key + "#" + strconv.FormatInt(start.Unix(), 10)
+	out, err := db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+		UpdateExpression: aws.String("ADD Hits :one SET #ttl = :ttl"),
+		ExpressionAttributeNames: map[string]string{
+			"#ttl": "ttl",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+			":ttl": &types.AttributeValueMemberN{Value: strconv.FormatInt(end.Unix(), 10)},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("count hit: %w", err)
+	}
+	v, _ := out.Attributes["Hits"].(*types.AttributeValueMemberN)
+	if v == nil {
+		return 0, end, nil
+	}
+	n, _ := strconv.Atoi(v.Value)
+	return n, end, nil
+}
+
+// RateBucket is the stored state of a token bucket.
+type RateBucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// ErrRateBucketChanged means another request updated a bucket between it
+// being read and written.
+var ErrRateBucketChanged = errors.New("rate bucket changed")
+
+func rateBucketPK(key string) string {
+	return "ratebucket#" + key
+}
+
+// GetRateBucket returns the bucket for key, or the zero RateBucket if there
+// isn't one.
+func GetRateBucket(ctx context.Context, key string) (RateBucket, error) {
+	db, err := client()
+	if err != nil {
+		return RateBucket{}, err
+	}
+
+	pk := rateBucketPK(key)
+	out, err := db.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName()),
+		Key: map[string]types.AttributeValue{
+			"uid": &types.AttributeValueMemberS{Value: pk},
+			"sk":  &types.AttributeValueMemberS{Value: pk},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return RateBucket{}, fmt.Errorf("get rate bucket: %w", err)
+	}
+
+	var b RateBucket
+	if v, ok := out.Item["Tokens"].(*types.AttributeValueMemberN); ok {
+		b.Tokens, _ = strconv.ParseFloat(v.Value, 64)
+	}
+	if v, ok := out.Item["UpdatedAt"].(*types.AttributeValueMemberN); ok {
+		n, _ := strconv.ParseInt(v.Value, 10, 64)
+		b.UpdatedAt = time.Unix(0, n)
+	}
+	return b, nil
+}
+
+// PutRateBucket replaces the bucket for key with b, as long as it's still
+// prev, and returns ErrRateBucketChanged if it isn't. The bucket expires
+// through TTL after idle, by when it would have refilled.
+func PutRateBucket(ctx context.Context, key string, b, prev RateBucket, idle time.Duration) error {
+	db, err := client()
+	if err != nil {
+		return err
+	}
+
+	pk := rateBucketPK(key)
+	in := &dynamodb.PutItemInput{
+		TableName: aws.String(TableName()),
+		Item: map[string]types.AttributeValue{
+			"uid":       &types.AttributeValueMemberS{Value: pk},
+			"sk":        &types.AttributeValueMemberS{Value: pk},
+			"Tokens":    &types.AttributeValueMemberN{Value: strconv.FormatFloat(b.Tokens, 'f', -1, 64)},
+			"UpdatedAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(b.UpdatedAt.UnixNano(), 10)},
+			"ttl":       &types.AttributeValueMemberN{Value: strconv.FormatInt(b.UpdatedAt.Add(idle).Unix(), 10)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(uid)"),
+	}
+	if !prev.UpdatedAt.IsZero() {
+		in.ConditionExpression = aws.String("UpdatedAt = :prev")
+		in.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":prev": &types.AttributeValueMemberN{Value: strconv.FormatInt(prev.UpdatedAt.UnixNano(), 10)},
+		}
+	}
+
+	_, err = db.PutItem(ctx, in)
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return ErrRateBucketChanged
+	}
+	if err != nil {
+		return fmt.Errorf("put rate bucket: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package dynamo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Each entry has a companion search index item in the user's partition,
+// keyed "search#<entry sk>", holding the normalized description and notes.
+// Searching reads just these small items instead of every entry.
+const searchPrefix = "search#"
+
+// SearchHit is an entry that matched a search query.
+type SearchHit struct {
+	SK          string  `json:"sk"`
+	Type        string  `json:"type"`
+	Description string  `json:"description,omitempty"`
+	Notes       string  `json:"notes,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+	Score       float64 `json:"score"`
+}
+
+// searchIndexItem builds an entry's index item. Fields copied from the entry
+// keep the entry's attribute names, so the same filters work on both.
+func searchIndexItem(e Entry) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		"uid":       &types.AttributeValueMemberS{Value: e.UID},
+		"sk":        &types.AttributeValueMemberS{Value: searchPrefix + e.SK},
+		"text":      &types.AttributeValueMemberS{Value: normalizeSearch(e.Description + " " + e.Notes)},
+		"type":      &types.AttributeValueMemberS{Value: e.Type},
+		"createdAt": &types.AttributeValueMemberS{Value: e.CreatedAt},
+	}
+	if e.Description != "" {
+		item["description"] = &types.AttributeValueMemberS{Value: e.Description}
+	}
+	if e.Notes != "" {
+		item["notes"] = &types.AttributeValueMemberS{Value: e.Notes}
+	}
+	return item
+}
+
+// PutSearchIndex writes (or rewrites) the search index item for an entry.
+func PutSearchIndex(ctx context.Context, e Entry) error {
+	db, err := Client()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(TableName()),
+		Item:      searchIndexItem(e),
+	})
+	if err != nil {
+		return fmt.Errorf("put search index: %w", err)
+	}
+	return nil
+}
+
+// SearchEntries does a case-insensitive, typo-tolerant search over the
+// descriptions and notes of all of a user's entries. Every word of the query
+// has to match a word in the entry, either exactly, as a prefix, as a
+// substring, or within a small edit distance. Results are ordered by score,
+// then newest first. entryType limits the search to one type when set.
+func SearchEntries(ctx context.Context, uid, query, entryType string, limit int) ([]SearchHit, error) {
+	terms := strings.Fields(normalizeSearch(query))
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("empty search query")
+	}
+
+	db, err := Client()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := searchPrefix
+	if entryType != "" {
+		prefix += entryType + "#"
+	}
+
+	var hits []SearchHit
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := db.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(TableName()),
+			KeyConditionExpression: aws.String("uid = :uid AND begins_with(sk, :prefix)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":uid":    &types.AttributeValueMemberS{Value: uid},
+				":prefix": &types.AttributeValueMemberS{Value: prefix},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("query search index: %w", err)
+		}
+
+		for _, item := range out.Items {
+			text, _ := item["text"].(*types.AttributeValueMemberS)
+			if text == nil {
+				continue
+			}
+			score := matchScore(terms, strings.Fields(text.Value))
+			if score == 0 {
+				continue
+			}
+
+			sk := item["sk"].(*types.AttributeValueMemberS).Value
+			hit := SearchHit{SK: strings.TrimPrefix(sk, searchPrefix), Score: score}
+			if v, ok := item["type"].(*types.AttributeValueMemberS); ok {
+				hit.Type = v.Value
+			}
+			if v, ok := item["description"].(*types.AttributeValueMemberS); ok {
+				hit.Description = v.Value
+			}
+			if v, ok := item["notes"].(*types.AttributeValueMemberS); ok {
+				hit.Notes = v.Value
+			}
+			if v, ok := item["createdAt"].(*types.AttributeValueMemberS); ok {
+				hit.CreatedAt = v.Value
+			}
+			hits = append(hits, hit)
+		}
+
+		if out.LastEvaluatedKey == nil {
+			break
+		}
+		startKey = out.LastEvaluatedKey
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].CreatedAt > hits[j].CreatedAt
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// normalizeSearch lowercases text and replaces punctuation with spaces.
+func normalizeSearch(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// matchScore returns the average of each term's best match against the
+// words, or 0 if any term doesn't match at all.
+func matchScore(terms, words []string) float64 {
+	var total float64
+	for _, t := range terms {
+		var best float64
+		for _, w := range words {
+			if s := termScore(t, w); s > best {
+				best = s
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	return total / float64(len(terms))
+}
+
+func termScore(term, word string) float64 {
+	switch {
+	case term == word:
+		return 1
+	case strings.HasPrefix(word, term):
+		return 0.9
+	case len(term) >= 3 && strings.Contains(word, term):
+		return 0.75
+	}
+
+	// Allow one typo in short words and two in longer ones
+	rt, rw := []rune(term), []rune(word)
+	maxDist := 0
+	switch {
+	case len(rt) >= 8:
+		maxDist = 2
+	case len(rt) >= 4:
+		maxDist = 1
+	}
+	if maxDist == 0 {
+		return 0
+	}
+	// Compare against the word's prefix too, so "chipolte" finds "chipotles"
+	candidates := []string{word}
+	if len(rw) > len(rt) {
+		candidates = append(candidates, string(rw[:len(rt)]))
+	}
+	for _, c := range candidates {
+		if d := editDistance(term, c); d <= maxDist {
+			return 0.7 - 0.1*float64(d)
+		}
+	}
+	return 0
+}
+
+// editDistance is the Damerau-Levenshtein (optimal string alignment)
+// distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
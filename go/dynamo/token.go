@@ -0,0 +1,125 @@
+package dynamo
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Kinds of token reported in TokenInfo.Kind.
+const (
+	TokenAccess  = "access_token"
+	TokenRefresh = "refresh_token"
+	TokenAPIKey  = "api_key"
+)
+
+// TokenInfo describes a live credential, for OAuth introspection and
+// revocation. ClientID is empty for API keys that weren't issued through
+// OAuth.
+type TokenInfo struct {
+	Kind      string
+	UID       string
+	ClientID  string
+	GrantID   string
+	KeyID     string
+	Scope     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// oauthKeyLabel is the label prefix of API keys handed out by the OAuth
+// server before it issued its own tokens.
+const oauthKeyLabel = "OAuth: "
+
+// InspectToken identifies a raw token as an OAuth access token, an unused
+// refresh token, or an API key, and returns nil if it's none of them (or has
+// expired). hint is an RFC 7009 token_type_hint and only changes the order
+// the lookups are tried in.
+func InspectToken(ctx context.Context, raw, hint string) (*TokenInfo, error) {
+	db, err := client()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := hashKey(raw)
+	grantLookup := func(kind, pk string) (*TokenInfo, error) {
+		rec, err := getTokenRecord(ctx, db, pk)
+		if err != nil || rec == nil || rec.Used || !time.Now().Before(rec.ExpiresAt) {
+			return nil, err
+		}
+		return &TokenInfo{
+			Kind:      kind,
+			UID:       rec.UID,
+			ClientID:  rec.ClientID,
+			GrantID:   rec.GrantID,
+			Scope:     rec.Scope,
+			IssuedAt:  rec.IssuedAt,
+			ExpiresAt: rec.ExpiresAt,
+		}, nil
+	}
+	apiKeyLookup := func() (*TokenInfo, error) {
+		uid, keyID, err := FindAPIKey(ctx, raw)
+		if err != nil || uid == "" {
+			return nil, err
+		}
+		key, err := GetAPIKey(ctx, uid, keyID)
+		if err != nil || key == nil || key.Expired() {
+			return nil, err
+		}
+		info := &TokenInfo{Kind: TokenAPIKey, UID: uid, KeyID: keyID, Scope: strings.Join(key.Scopes, " ")}
+		if strings.HasPrefix(key.Label, oauthKeyLabel) {
+			info.ClientID = strings.TrimPrefix(key.Label, oauthKeyLabel)
+		}
+		info.IssuedAt, _ = time.Parse(time.RFC3339, key.CreatedAt)
+		info.ExpiresAt, _ = time.Parse(time.RFC3339, key.ExpiresAt)
+		return info, nil
+	}
+
+	lookups := []func() (*TokenInfo, error){
+		func() (*TokenInfo, error) { return grantLookup(TokenAccess, accessLookupPK(hash)) },
+		func() (*TokenInfo, error) { return grantLookup(TokenRefresh, refreshLookupPK(hash)) },
+		apiKeyLookup,
+	}
+	switch hint {
+	case TokenRefresh:
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	case TokenAPIKey:
+		lookups = append(lookups[2:], lookups[:2]...)
+	}
+
+	for _, lookup := range lookups {
+		info, err := lookup()
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			return info, nil
+		}
+	}
+	return nil, nil
+}
+
+// RevokeToken revokes the credential behind a TokenInfo. Access and refresh
+// tokens revoke their whole grant; API keys are deleted along with their
+// lookup record.
+func RevokeToken(ctx context.Context, info TokenInfo) error {
+	if info.Kind == TokenAPIKey {
+		return DeleteAPIKey(ctx, info.UID, info.KeyID)
+	}
+	return RevokeGrant(ctx, info.UID, info.GrantID)
+}
+
+var revokeHooks []func(tokenHash string)
+
+// OnTokenRevoked registers fn to be called with the hash of every API key or
+// access token revoked by this process, so in-memory caches of token lookups
+// can drop it right away.
+func OnTokenRevoked(fn func(tokenHash string)) {
+	revokeHooks = append(revokeHooks, fn)
+}
+
+func tokenRevoked(tokenHash string) {
+	for _, fn := range revokeHooks {
+		fn(tokenHash)
+	}
+}
@@ -2,33 +2,54 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 	_ "time/tzdata"
 
+	"github.com/BrianLeishman/justlog.io/go/config"
 	"github.com/BrianLeishman/justlog.io/go/dynamo"
 	mcpauth "github.com/BrianLeishman/justlog.io/go/lambda/mcp/auth"
+	"github.com/BrianLeishman/justlog.io/go/logging"
+	"github.com/BrianLeishman/justlog.io/go/ratelimit"
+	"github.com/BrianLeishman/justlog.io/go/stats"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/awslabs/aws-lambda-go-api-proxy/httpadapter"
 )
 
 func main() {
+	logging.Setup(os.Stderr)
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/token", handleToken)
+	mux.HandleFunc("/api/token/rotate", handleRotateToken)
+	mux.HandleFunc("/api/connections", handleConnections)
 	mux.HandleFunc("/api/profile", handleProfile)
+	mux.HandleFunc("/api/weight/trend", handleWeightTrend)
+	mux.HandleFunc("/api/report", handleReport)
+	mux.HandleFunc("/api/search", handleSearch)
+	mux.HandleFunc("/api/audit", handleAudit)
 	mux.HandleFunc("/", handleEntries)
 
-	handler := cors(mux)
+	handler := cors(withAuditIP(logging.Handler(ratelimit.Handler(mux))))
 
 	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
 		adapter := httpadapter.NewV2(handler)
 		lambda.Start(adapter.ProxyWithContext)
 	} else {
-		log.Println("API server listening on :8080")
-		log.Fatal(http.ListenAndServe(":8080", handler))
+		slog.Info("API server listening", "addr", cfg.APIAddr)
+		log.Fatal(http.ListenAndServe(cfg.APIAddr, handler))
 	}
 }
 
@@ -37,6 +58,7 @@ func cors(next http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After, X-Request-Id")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -45,6 +67,18 @@ func cors(next http.Handler) http.Handler {
 	})
 }
 
+// withAuditIP notes the client's address for audit events recorded while
+// handling the request.
+func withAuditIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		next.ServeHTTP(w, r.WithContext(dynamo.WithAuditIP(r.Context(), ip)))
+	})
+}
+
 func handleToken(w http.ResponseWriter, r *http.Request) {
 	// Auth via Cognito access token (short-lived) to issue/revoke API key
 	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
@@ -55,15 +89,23 @@ func handleToken(w http.ResponseWriter, r *http.Request) {
 
 	u, err := mcpauth.FromToken(r.Context(), token)
 	if err != nil {
-		log.Printf("auth error: %v", err)
+		slog.WarnContext(r.Context(), "auth failed", "err", err)
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
+	// A scoped credential could otherwise mint itself a broader one
+	if !u.FullAccess() {
+		http.Error(w, "forbidden: managing API keys requires full access", http.StatusForbidden)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodPost:
 		var req struct {
-			Label string `json:"label"`
+			Label         string   `json:"label"`
+			Scopes        []string `json:"scopes"`
+			ExpiresInDays int      `json:"expires_in_days"`
 		}
 		if r.Body != nil {
 			_ = json.NewDecoder(r.Body).Decode(&req)
@@ -71,10 +113,19 @@ func handleToken(w http.ResponseWriter, r *http.Request) {
 		if req.Label == "" {
 			req.Label = "Web UI"
 		}
+		scopes, err := mcpauth.ParseScopes(strings.Join(req.Scopes, " "))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		expiresAt, ok := keyExpiry(w, req.ExpiresInDays)
+		if !ok {
+			return
+		}
 
-		key, keyID, err := dynamo.CreateAPIKey(r.Context(), u.Sub, req.Label)
+		key, keyID, err := dynamo.CreateAPIKey(r.Context(), u.Sub, req.Label, scopes, expiresAt)
 		if err != nil {
-			log.Printf("create api key error: %v", err)
+			slog.ErrorContext(r.Context(), "create api key", "err", err)
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
 		}
@@ -87,7 +138,7 @@ func handleToken(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		keys, err := dynamo.ListAPIKeys(r.Context(), u.Sub)
 		if err != nil {
-			log.Printf("list api keys error: %v", err)
+			slog.ErrorContext(r.Context(), "list api keys", "err", err)
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
 		}
@@ -101,10 +152,153 @@ func handleToken(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if err := dynamo.DeleteAPIKey(r.Context(), u.Sub, keyID); err != nil {
-			log.Printf("delete api key error: %v", err)
+			slog.ErrorContext(r.Context(), "delete api key", "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// keyExpiry turns an expires_in_days request field into an expiry time,
+// writing a 400 if it's out of range. Zero means the key never expires.
+func keyExpiry(w http.ResponseWriter, days int) (time.Time, bool) {
+	if days < 0 || days > 3650 {
+		http.Error(w, "expires_in_days must be between 0 and 3650", http.StatusBadRequest)
+		return time.Time{}, false
+	}
+	if days == 0 {
+		return time.Time{}, true
+	}
+	return time.Now().AddDate(0, 0, days), true
+}
+
+// handleRotateToken replaces an API key with a new one. The old key keeps
+// working for a grace period so integrations can be switched over.
+func handleRotateToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := mcpauth.FromToken(r.Context(), token)
+	if err != nil {
+		slog.WarnContext(r.Context(), "auth failed", "err", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !u.FullAccess() {
+		http.Error(w, "forbidden: managing API keys requires full access", http.StatusForbidden)
+		return
+	}
+
+	keyID := r.URL.Query().Get("id")
+	if keyID == "" {
+		http.Error(w, "id parameter required", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		GraceHours    *int `json:"grace_hours"`
+		ExpiresInDays int  `json:"expires_in_days"`
+	}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&req)
+	}
+	grace := dynamo.APIKeyRotationGrace
+	if req.GraceHours != nil {
+		if *req.GraceHours < 0 || *req.GraceHours > 24*30 {
+			http.Error(w, "grace_hours must be between 0 and 720", http.StatusBadRequest)
+			return
+		}
+		grace = time.Duration(*req.GraceHours) * time.Hour
+	}
+	expiresAt, ok := keyExpiry(w, req.ExpiresInDays)
+	if !ok {
+		return
+	}
+
+	key, newKeyID, oldExpiresAt, err := dynamo.RotateAPIKey(r.Context(), u.Sub, keyID, grace, expiresAt)
+	if errors.Is(err, dynamo.ErrKeyNotRotatable) {
+		http.Error(w, "api key has already been rotated or has expired", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rotate api key", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if key == "" {
+		http.Error(w, "api key not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"api_key":            key,
+		"key_id":             newKeyID,
+		"old_key_expires_at": oldExpiresAt.Format(time.RFC3339),
+	})
+}
+
+// handleConnections lists the OAuth apps a user has connected, or
+// disconnects one by revoking all of its credentials.
+func handleConnections(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := mcpauth.FromToken(r.Context(), token)
+	if err != nil {
+		slog.WarnContext(r.Context(), "auth failed", "err", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !u.FullAccess() {
+		http.Error(w, "forbidden: managing connected apps requires full access", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		conns, err := dynamo.ListConnections(r.Context(), u.Sub)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "list connections", "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conns)
+
+	case http.MethodDelete:
+		clientID := r.URL.Query().Get("client_id")
+		if clientID == "" {
+			http.Error(w, "client_id parameter required", http.StatusBadRequest)
+			return
+		}
+		found, err := dynamo.RevokeConnection(r.Context(), u.Sub, clientID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "revoke connection", "err", err)
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
 		}
+		if !found {
+			http.Error(w, "connection not found", http.StatusNotFound)
+			return
+		}
+		if err := dynamo.RecordAudit(r.Context(), u.Sub, dynamo.AuditEvent{Type: dynamo.AuditConnectionRevoked, ClientID: clientID}); err != nil {
+			slog.ErrorContext(r.Context(), "record audit event", "err", err)
+		}
 		w.WriteHeader(http.StatusNoContent)
 
 	default:
@@ -112,6 +306,16 @@ func handleToken(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// requireScopes writes a 403 and returns false if the user's credential
+// lacks any of scopes.
+func requireScopes(w http.ResponseWriter, u mcpauth.User, scopes ...string) bool {
+	if missing := u.Missing(scopes...); missing != "" {
+		http.Error(w, "forbidden: requires the "+missing+" scope", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 func handleEntries(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -127,7 +331,7 @@ func handleEntries(w http.ResponseWriter, r *http.Request) {
 
 	u, err := mcpauth.FromToken(r.Context(), token)
 	if err != nil {
-		log.Printf("auth error: %v", err)
+		slog.WarnContext(r.Context(), "auth failed", "err", err)
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -139,6 +343,9 @@ func handleEntries(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "type parameter required (food, exercise, weight)", http.StatusBadRequest)
 		return
 	}
+	if !requireScopes(w, u, mcpauth.EntryScope(entryType, false)) {
+		return
+	}
 
 	loc := time.UTC
 	if profile, err := dynamo.GetProfile(r.Context(), u.Sub); err == nil && profile != nil {
@@ -168,7 +375,7 @@ func handleEntries(w http.ResponseWriter, r *http.Request) {
 
 	entries, err := dynamo.GetEntries(r.Context(), u.Sub, entryType, from, to)
 	if err != nil {
-		log.Printf("dynamo error: %v", err)
+		slog.ErrorContext(r.Context(), "get entries", "err", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
@@ -192,9 +399,12 @@ func handleProfile(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
+		if !requireScopes(w, u, mcpauth.ScopeProfileRead) {
+			return
+		}
 		profile, err := dynamo.GetProfile(r.Context(), u.Sub)
 		if err != nil {
-			log.Printf("get profile error: %v", err)
+			slog.ErrorContext(r.Context(), "get profile", "err", err)
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
 		}
@@ -202,13 +412,16 @@ func handleProfile(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(profile)
 
 	case http.MethodPut:
+		if !requireScopes(w, u, mcpauth.ScopeProfileWrite) {
+			return
+		}
 		var fields map[string]string
 		if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
 			http.Error(w, "invalid body", http.StatusBadRequest)
 			return
 		}
 		if err := dynamo.UpdateProfile(r.Context(), u.Sub, fields); err != nil {
-			log.Printf("update profile error: %v", err)
+			slog.ErrorContext(r.Context(), "update profile", "err", err)
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
 		}
@@ -217,3 +430,228 @@ func handleProfile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+func handleWeightTrend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := mcpauth.FromToken(r.Context(), token)
+	if err != nil {
+		slog.WarnContext(r.Context(), "auth failed", "err", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScopes(w, u, mcpauth.ScopeWeightRead) {
+		return
+	}
+
+	days := 90
+	if v := r.URL.Query().Get("days"); v != "" {
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 7 || days > 365 {
+			http.Error(w, "days must be between 7 and 365", http.StatusBadRequest)
+			return
+		}
+	}
+
+	profile, err := dynamo.GetProfile(r.Context(), u.Sub)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "get profile", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	loc := profile.Timezone()
+
+	now := time.Now().In(loc)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1).UTC()
+	from := to.AddDate(0, 0, -days)
+
+	entries, err := dynamo.GetEntries(r.Context(), u.Sub, "weight", from, to)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "get entries", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	var goal float64
+	if u.Allows(mcpauth.ScopeProfileRead) {
+		goal, _ = stats.ParseWeight(profile["ideal_weight"])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats.AnalyzeTrend(entries, loc, goal))
+}
+
+func handleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := mcpauth.FromToken(r.Context(), token)
+	if err != nil {
+		slog.WarnContext(r.Context(), "auth failed", "err", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !requireScopes(w, u, mcpauth.ScopeEntriesRead, mcpauth.ScopeProfileRead) {
+		return
+	}
+
+	profile, err := dynamo.GetProfile(r.Context(), u.Sub)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "get profile", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	loc := profile.Timezone()
+
+	q := r.URL.Query()
+	period := q.Get("period")
+	if period == "" {
+		period = "week"
+	}
+	date := time.Now().In(loc)
+	if v := q.Get("date"); v != "" {
+		date, err = time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			http.Error(w, "invalid date", http.StatusBadRequest)
+			return
+		}
+	}
+	if period != "week" && period != "month" {
+		http.Error(w, "period must be week or month", http.StatusBadRequest)
+		return
+	}
+
+	report, err := stats.LoadReport(r.Context(), u.Sub, profile, period, date)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "report", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if q.Get("format") == "markdown" {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write([]byte(report.Markdown()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := mcpauth.FromToken(r.Context(), token)
+	if err != nil {
+		slog.WarnContext(r.Context(), "auth failed", "err", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	query := q.Get("q")
+	if strings.TrimSpace(query) == "" {
+		http.Error(w, "q parameter required", http.StatusBadRequest)
+		return
+	}
+	entryType := q.Get("type")
+	if entryType != "" && entryType != "food" && entryType != "exercise" && entryType != "weight" {
+		http.Error(w, "type must be food, exercise or weight", http.StatusBadRequest)
+		return
+	}
+	if !requireScopes(w, u, mcpauth.EntryScope(entryType, false)) {
+		return
+	}
+	limit := 20
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 100 {
+			http.Error(w, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+
+	hits, err := dynamo.SearchEntries(r.Context(), u.Sub, query, entryType, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "search", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if hits == nil {
+		hits = []dynamo.SearchHit{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hits)
+}
+
+// handleAudit lists the user's audit events, newest first. Pass the last
+// event's id as before to page back through older ones.
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := mcpauth.FromToken(r.Context(), token)
+	if err != nil {
+		slog.WarnContext(r.Context(), "auth failed", "err", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !u.FullAccess() {
+		http.Error(w, "forbidden: reading the audit log requires full access", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	limit := 50
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 200 {
+			http.Error(w, "limit must be between 1 and 200", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := dynamo.ListAuditEvents(r.Context(), u.Sub, q.Get("before"), limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "list audit events", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
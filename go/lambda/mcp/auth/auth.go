@@ -6,8 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/BrianLeishman/justlog.io/go/config"
 	"github.com/BrianLeishman/justlog.io/go/dynamo"
+	"github.com/BrianLeishman/justlog.io/go/logging"
 )
 
 type contextKey struct{}
@@ -17,22 +21,153 @@ type User struct {
 	Email   string `json:"email"`
 	Name    string `json:"username"`
 	Picture string `json:"picture"`
+
+	// Scopes limits what the credential the user authenticated with can do.
+	// Empty means full access.
+	Scopes []string `json:"-"`
+	// Credential identifies the token the user authenticated with, as its
+	// SHA-256 hash, so limits can apply per key. Empty for users that
+	// didn't present one.
+	Credential string `json:"-"`
+	// ExpiresAt is when the credential stops working, so a cached lookup
+	// doesn't outlive it. Zero if it doesn't expire or isn't known.
+	ExpiresAt time.Time `json:"-"`
 }
 
-const cognitoDomain = "https://justlog.auth.us-east-1.amazoncognito.com"
+// ErrInvalidToken means a bearer token isn't a valid credential, as opposed
+// to it not being checkable right now.
+var ErrInvalidToken = errors.New("invalid token")
 
+// FromToken authenticates a bearer token: an API key, an access token from
+// our OAuth server, or a JWT from Cognito or another identity provider.
+// Results, including unknown tokens, are cached for up to CacheTTL(), and
+// never past the credential's own expiry.
+// Rejected tokens are recorded in the audit log, and the user is noted on
+// the request's logging context.
 func FromToken(ctx context.Context, accessToken string) (User, error) {
-	// Try API key lookup first
-	if uid, err := dynamo.LookupAPIKey(ctx, accessToken); err == nil {
-		return User{Sub: uid}, nil
+	hash := tokenHash(accessToken)
+	if u, valid, found := cache.get(hash); found {
+		if !valid {
+			return User{}, ErrInvalidToken
+		}
+		logging.SetUser(ctx, u.Sub)
+		return u, nil
+	}
+
+	u, err := lookupToken(ctx, accessToken)
+	switch {
+	case err == nil:
+		u.Credential = hash
+		cache.put(hash, u, true)
+		logging.SetUser(ctx, u.Sub)
+	case errors.Is(err, ErrInvalidToken):
+		cache.put(hash, User{}, false)
+		auditFailure(ctx, accessToken)
+	}
+	return u, err
+}
+
+// authFailureAuditsPerMinute bounds how many rejected tokens from one
+// address are recorded, so spraying random tokens can't flood the audit
+// log's global stream.
+const authFailureAuditsPerMinute = 10
+
+// auditFailure records a rejected token. API keys that still exist, such as
+// expired ones, are recorded against their owner, since only the key's
+// holder can present it. Anything else goes to the global stream: an
+// unverified token can't be trusted to say whose it is. Past
+// authFailureAuditsPerMinute from an address, one event notes that further
+// failures aren't recorded and the rest are dropped.
+func auditFailure(ctx context.Context, token string) {
+	ip := dynamo.AuditIP(ctx)
+	hits, reset, err := dynamo.CountHit(ctx, "auth_failure#"+ip, time.Minute)
+	switch {
+	case err != nil || hits > authFailureAuditsPerMinute+1:
+		return
+	case hits == authFailureAuditsPerMinute+1:
+		_ = dynamo.RecordAudit(ctx, dynamo.AuditGlobal, dynamo.AuditEvent{
+			Type:   dynamo.AuditAuthFailed,
+			Detail: "too many failures, not recording more until " + reset.UTC().Format(time.RFC3339),
+		})
+		return
+	}
+
+	uid := dynamo.AuditGlobal
+	e := dynamo.AuditEvent{Type: dynamo.AuditAuthFailed, Detail: "unknown token"}
+	if looksLikeJWT(token) {
+		e.Detail = "invalid JWT"
+	} else if owner, keyID, err := dynamo.FindAPIKey(ctx, token); err == nil && owner != "" {
+		uid, e.KeyID, e.Detail = owner, keyID, "expired or invalid API key"
+	}
+	// Failing to record it doesn't change the answer
+	_ = dynamo.RecordAudit(ctx, uid, e)
+}
+
+func lookupToken(ctx context.Context, accessToken string) (User, error) {
+	switch {
+	case strings.HasPrefix(accessToken, dynamo.APIKeyPrefix):
+		uid, scopes, expiresAt, err := dynamo.LookupAPIKey(ctx, accessToken)
+		if errors.Is(err, dynamo.ErrTokenNotFound) {
+			return User{}, ErrInvalidToken
+		}
+		if err != nil {
+			return User{}, err
+		}
+		return User{Sub: uid, Scopes: scopes, ExpiresAt: expiresAt}, nil
+
+	case looksLikeJWT(accessToken):
+		return fromJWT(ctx, accessToken)
+	}
+
+	// Legacy unprefixed API keys
+	uid, scopes, expiresAt, err := dynamo.LookupAPIKey(ctx, accessToken)
+	if err == nil {
+		return User{Sub: uid, Scopes: scopes, ExpiresAt: expiresAt}, nil
+	}
+	if !errors.Is(err, dynamo.ErrTokenNotFound) {
+		return User{}, err
+	}
+
+	// Then access tokens issued by our OAuth server
+	uid, scope, expiresAt, err := dynamo.LookupAccessToken(ctx, accessToken)
+	if errors.Is(err, dynamo.ErrTokenNotFound) {
+		return User{}, ErrInvalidToken
+	}
+	if err != nil {
+		return User{}, err
 	}
+	return User{Sub: uid, Scopes: strings.Fields(scope), ExpiresAt: expiresAt}, nil
+}
 
-	// Fall back to Cognito access token
-	return FromCognito(ctx, accessToken)
+// looksLikeJWT reports whether a token has the three base64url segments of a
+// JWT, as Cognito and OpenID Connect tokens do. Only those are worth
+// verifying as one.
+func looksLikeJWT(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	for _, p := range parts {
+		if p == "" || strings.Trim(p, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+			return false
+		}
+	}
+	return true
 }
 
+// FromCognito authenticates a Cognito access token, verifying it locally
+// when Cognito is configured and asking the userInfo endpoint otherwise.
+// Locally verified users only have the profile fields carried in the token.
 func FromCognito(ctx context.Context, accessToken string) (User, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cognitoDomain+"/oauth2/userInfo", nil)
+	if v := Cognito(); v != nil {
+		c, err := v.Verify(ctx, accessToken)
+		if err != nil {
+			return User{}, err
+		}
+		return c.User(), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.Get().CognitoDomain+"/oauth2/userInfo", nil)
 	if err != nil {
 		return User{}, err
 	}
@@ -44,6 +179,9 @@ func FromCognito(ctx context.Context, accessToken string) (User, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		return User{}, fmt.Errorf("%w: cognito userInfo returned %d", ErrInvalidToken, resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return User{}, fmt.Errorf("cognito userInfo returned %d", resp.StatusCode)
 	}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/BrianLeishman/justlog.io/go/config"
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+)
+
+// Unknown tokens are remembered for less time than known ones, so a key
+// that was just created isn't refused for long.
+const (
+	negativeCacheTTL = 10 * time.Second
+	cacheMaxEntries  = 10000
+)
+
+// CacheTTL bounds how long a token lookup is reused. Revocations in this
+// process take effect immediately; revocations elsewhere (another Lambda
+// instance) take effect within CacheTTL. It's the auth_cache_ttl setting; 0
+// disables caching.
+func CacheTTL() time.Duration {
+	return time.Duration(config.Get().AuthCacheTTL)
+}
+
+type cacheEntry struct {
+	user    User
+	valid   bool
+	expires time.Time
+}
+
+// tokenCache maps token hashes to lookup results. Tokens are stored hashed
+// (the same SHA-256 hex the table uses) so raw secrets don't sit in memory
+// longer than the request that presented them.
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+var cache = &tokenCache{entries: map[string]cacheEntry{}}
+
+func init() {
+	dynamo.OnTokenRevoked(cache.invalidate)
+}
+
+func tokenHash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// get returns a cached result. found is false on a miss; valid is false for
+// a token that's known to be bad.
+func (c *tokenCache) get(hash string) (u User, valid, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[hash]
+	if !ok || time.Now().After(e.expires) {
+		return User{}, false, false
+	}
+	return e.user, e.valid, true
+}
+
+func (c *tokenCache) put(hash string, u User, valid bool) {
+	ttl := CacheTTL()
+	if !valid {
+		ttl = min(ttl, negativeCacheTTL)
+	}
+	// Don't keep accepting a credential after it expires
+	if valid && !u.ExpiresAt.IsZero() {
+		ttl = min(ttl, time.Until(u.ExpiresAt))
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= cacheMaxEntries {
+		c.evict()
+	}
+	c.entries[hash] = cacheEntry{user: u, valid: valid, expires: time.Now().Add(ttl)}
+}
+
+// evict drops expired entries, and if that isn't enough, an arbitrary half
+// of the rest. Callers hold the lock.
+func (c *tokenCache) evict() {
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	for k := range c.entries {
+		if len(c.entries) < cacheMaxEntries/2 {
+			break
+		}
+		delete(c.entries, k)
+	}
+}
+
+func (c *tokenCache) invalidate(hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, hash)
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BrianLeishman/justlog.io/go/config"
+)
+
+// How long a fetched key set is trusted, how often an unknown key ID may
+// trigger a refetch (Cognito rotates keys rarely), and how much clock skew
+// is tolerated on exp and iat.
+const (
+	jwksTTL          = time.Hour
+	jwksRefetchAfter = 5 * time.Minute
+	jwtLeeway        = time.Minute
+)
+
+// JWTVerifier checks RS256 JWTs locally against an issuer's published
+// signing keys: Cognito tokens instead of calling userInfo for every
+// request, and ID and access tokens from other OpenID Connect providers.
+type JWTVerifier struct {
+	// Issuer is the user pool URL, e.g.
+	// https://cognito-idp.us-east-1.amazonaws.com/us-east-1_XXXXXXXXX.
+	Issuer string
+	// JWKSURL defaults to the issuer's /.well-known/jwks.json, which is
+	// where Cognito publishes it. Other providers' is in their discovery
+	// document.
+	JWKSURL string
+	// ClientIDs are the app clients whose tokens are accepted.
+	ClientIDs []string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// Cognito returns the verifier for Cognito tokens, built from config the
+// first time it's needed. It's nil unless an issuer is configured, in which
+// case FromCognito falls back to the userInfo endpoint.
+var Cognito = sync.OnceValue(func() *JWTVerifier {
+	cfg := config.Get()
+	if cfg.CognitoIssuer == "" {
+		return nil
+	}
+	return &JWTVerifier{
+		Issuer:    cfg.CognitoIssuer,
+		JWKSURL:   cfg.CognitoJWKSURL,
+		ClientIDs: cfg.CognitoClientIDs,
+	}
+})
+
+// Claims are the parts of a Cognito or OpenID Connect token we use.
+type Claims struct {
+	Sub               string   `json:"sub"`
+	Email             string   `json:"email"`
+	Picture           string   `json:"picture"`
+	Username          string   `json:"username"`
+	IDUsername        string   `json:"cognito:username"`
+	PreferredUsername string   `json:"preferred_username"`
+	Name              string   `json:"name"`
+	TokenUse          string   `json:"token_use"`
+	ClientID          string   `json:"client_id"`
+	AuthorizedParty   string   `json:"azp"`
+	Audience          audience `json:"aud"`
+	Issuer            string   `json:"iss"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+}
+
+// audience is the aud claim, which OpenID Connect allows to be a single
+// string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// User returns the user the claims describe.
+func (c Claims) User() User {
+	name := cmp.Or(c.Username, c.IDUsername, c.PreferredUsername, c.Name)
+	u := User{Sub: c.Sub, Email: c.Email, Name: name, Picture: c.Picture}
+	if c.ExpiresAt != 0 {
+		u.ExpiresAt = time.Unix(c.ExpiresAt, 0)
+	}
+	return u
+}
+
+// Verify checks a token's RS256 signature, issuer, client, token use and
+// lifetime, and returns its claims. Tokens that fail a check give an error
+// wrapping ErrInvalidToken; failing to fetch the signing keys doesn't.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed jwt", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, fmt.Errorf("%w: jwt header: %w", ErrInvalidToken, err)
+	}
+	if header.Alg != "RS256" {
+		return Claims{}, fmt.Errorf("%w: unsupported jwt alg %q", ErrInvalidToken, header.Alg)
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: jwt signature: %w", ErrInvalidToken, err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return Claims{}, fmt.Errorf("%w: invalid jwt signature", ErrInvalidToken)
+	}
+
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return Claims{}, fmt.Errorf("%w: jwt claims: %w", ErrInvalidToken, err)
+	}
+	if c.Issuer != v.Issuer {
+		return Claims{}, fmt.Errorf("%w: unexpected jwt issuer %q", ErrInvalidToken, c.Issuer)
+	}
+	switch c.TokenUse {
+	case "access":
+		if !slices.Contains(v.ClientIDs, c.ClientID) {
+			return Claims{}, fmt.Errorf("%w: unexpected jwt client_id %q", ErrInvalidToken, c.ClientID)
+		}
+	case "id":
+		if !v.forClient(c.Audience...) {
+			return Claims{}, fmt.Errorf("%w: unexpected jwt audience %q", ErrInvalidToken, c.Audience)
+		}
+	case "":
+		// Not Cognito. ID tokens name the client in aud; access tokens from
+		// providers such as Keycloak carry an API audience and name the
+		// client in azp.
+		if !v.forClient(c.Audience...) && !v.forClient(c.AuthorizedParty) {
+			return Claims{}, fmt.Errorf("%w: unexpected jwt audience %q", ErrInvalidToken, c.Audience)
+		}
+	default:
+		return Claims{}, fmt.Errorf("%w: unexpected jwt token_use %q", ErrInvalidToken, c.TokenUse)
+	}
+	now := time.Now()
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(jwtLeeway)) {
+		return Claims{}, fmt.Errorf("%w: jwt expired", ErrInvalidToken)
+	}
+	if c.IssuedAt != 0 && time.Unix(c.IssuedAt, 0).After(now.Add(jwtLeeway)) {
+		return Claims{}, fmt.Errorf("%w: jwt issued in the future", ErrInvalidToken)
+	}
+	if c.Sub == "" {
+		return Claims{}, fmt.Errorf("%w: jwt has no sub", ErrInvalidToken)
+	}
+	return c, nil
+}
+
+func (v *JWTVerifier) forClient(ids ...string) bool {
+	for _, id := range ids {
+		if id != "" && slices.Contains(v.ClientIDs, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// key returns the signing key for a key ID, fetching the key set if it's
+// stale or doesn't have that key yet.
+func (v *JWTVerifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	age := time.Since(v.fetchedAt)
+	if k, ok := v.keys[kid]; ok && age < jwksTTL {
+		return k, nil
+	}
+	if v.keys == nil || age >= jwksRefetchAfter {
+		keys, err := v.fetchKeys(ctx)
+		if err != nil {
+			// Keep using the old keys if Cognito is briefly unreachable
+			if k, ok := v.keys[kid]; ok {
+				return k, nil
+			}
+			return nil, err
+		}
+		v.keys, v.fetchedAt = keys, time.Now()
+	}
+	if k, ok := v.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: unknown jwt key id %q", ErrInvalidToken, kid)
+}
+
+func (v *JWTVerifier) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	url := v.JWKSURL
+	if url == "" {
+		url = strings.TrimSuffix(v.Issuer, "/") + "/.well-known/jwks.json"
+	}
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks returned %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Alg string `json:"alg"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decode jwks: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Alg != "" && k.Alg != "RS256") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks has no usable keys")
+	}
+	return keys, nil
+}
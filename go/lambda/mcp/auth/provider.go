@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/BrianLeishman/justlog.io/go/config"
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+)
+
+// CognitoProvider is the name of the built-in Cognito provider.
+const CognitoProvider = "cognito"
+
+// Provider is an identity provider users sign in through when they
+// authorize an OAuth client: Cognito, or any OpenID Connect provider found
+// through discovery. Cognito subs are JustLog user IDs; other providers'
+// subs are mapped to user IDs the first time they sign in.
+type Provider struct {
+	Name  string
+	Title string
+
+	clientID     string
+	clientSecret string
+	scopes       []string
+	issuer       string
+
+	mu       sync.Mutex
+	meta     *providerMetadata
+	verifier *JWTVerifier
+}
+
+// providerMetadata is the part of an OpenID Connect discovery document we
+// use.
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Providers returns the configured identity providers, Cognito first when
+// it's configured.
+var Providers = sync.OnceValue(func() []*Provider {
+	cfg := config.Get()
+	var ps []*Provider
+	if cfg.CognitoDomain != "" {
+		ps = append(ps, &Provider{
+			Name:     CognitoProvider,
+			Title:    "JustLog",
+			clientID: cfg.CognitoClientID,
+			scopes:   []string{"openid", "email", "profile"},
+			meta: &providerMetadata{
+				AuthorizationEndpoint: cfg.CognitoDomain + "/oauth2/authorize",
+				TokenEndpoint:         cfg.CognitoDomain + "/oauth2/token",
+				UserinfoEndpoint:      cfg.CognitoDomain + "/oauth2/userInfo",
+			},
+		})
+	}
+	for _, p := range cfg.IdentityProviders {
+		ps = append(ps, &Provider{
+			Name:         p.Name,
+			Title:        p.Title,
+			clientID:     p.ClientID,
+			clientSecret: p.ClientSecret,
+			scopes:       p.Scopes,
+			issuer:       p.Issuer,
+		})
+	}
+	return ps
+})
+
+// ProviderByName returns a configured provider, or nil.
+func ProviderByName(name string) *Provider {
+	for _, p := range Providers() {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func providerByIssuer(issuer string) *Provider {
+	for _, p := range Providers() {
+		if p.issuer != "" && p.issuer == issuer {
+			return p
+		}
+	}
+	return nil
+}
+
+// metadata returns the provider's endpoints, running discovery the first
+// time. Failed discovery isn't cached, so a provider that was briefly down
+// works on the next attempt.
+func (p *Provider) metadata(ctx context.Context) (*providerMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s discovery: %w", p.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s discovery returned %d", p.Name, resp.StatusCode)
+	}
+
+	var meta providerMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("decode %s discovery: %w", p.Name, err)
+	}
+	if strings.TrimSuffix(meta.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("%s discovery is for issuer %q", p.Name, meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%s discovery is missing endpoints", p.Name)
+	}
+
+	p.meta = &meta
+	p.verifier = &JWTVerifier{
+		Issuer:    meta.Issuer,
+		JWKSURL:   meta.JWKSURI,
+		ClientIDs: []string{p.clientID},
+	}
+	return p.meta, nil
+}
+
+// AuthCodeURL returns the URL to send the user to for signing in. The
+// provider redirects back to redirectURI with a code and the given state.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, redirectURI string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%s authorization endpoint: %w", p.Name, err)
+	}
+	q := u.Query()
+	q.Set("client_id", p.clientID)
+	q.Set("response_type", "code")
+	q.Set("scope", strings.Join(p.scopes, " "))
+	q.Set("redirect_uri", redirectURI)
+	q.Set("state", state)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code from the provider and returns who
+// signed in. User.Sub is the JustLog user ID. The provider's own tokens are
+// only used to identify the user and are never kept.
+func (p *Provider) Exchange(ctx context.Context, code, redirectURI string) (User, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return User{}, err
+	}
+
+	body := url.Values{
+		"grant_type":   {"authorization_code"},
+		"client_id":    {p.clientID},
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(body.Encode()))
+	if err != nil {
+		return User{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return User{}, fmt.Errorf("%s token exchange: %w", p.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return User{}, fmt.Errorf("%s token returned %d", p.Name, resp.StatusCode)
+	}
+
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return User{}, fmt.Errorf("decode %s tokens: %w", p.Name, err)
+	}
+
+	if p.Name == CognitoProvider {
+		return FromCognito(ctx, tokens.AccessToken)
+	}
+
+	var c Claims
+	switch {
+	case tokens.IDToken != "":
+		c, err = p.verifier.Verify(ctx, tokens.IDToken)
+	case meta.UserinfoEndpoint != "":
+		c, err = p.userInfo(ctx, meta.UserinfoEndpoint, tokens.AccessToken)
+	default:
+		err = errors.New("no id_token and no userinfo endpoint")
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("%s sign-in: %w", p.Name, err)
+	}
+	return p.user(ctx, c)
+}
+
+func (p *Provider) userInfo(ctx context.Context, endpoint, accessToken string) (Claims, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Claims{}, fmt.Errorf("userinfo: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Claims{}, fmt.Errorf("userinfo returned %d", resp.StatusCode)
+	}
+
+	var c Claims
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return Claims{}, fmt.Errorf("decode userinfo: %w", err)
+	}
+	if c.Sub == "" {
+		return Claims{}, errors.New("userinfo has no sub")
+	}
+	return c, nil
+}
+
+// user maps a provider's claims onto the JustLog user they belong to.
+func (p *Provider) user(ctx context.Context, c Claims) (User, error) {
+	uid, err := dynamo.ResolveIdentity(ctx, p.Name, c.Sub, c.Email)
+	if err != nil {
+		return User{}, err
+	}
+	u := c.User()
+	u.Sub = uid
+	return u, nil
+}
+
+// fromJWT authenticates a bearer JWT issued by a configured OpenID Connect
+// provider. Tokens from any other issuer are taken to be Cognito's.
+func fromJWT(ctx context.Context, token string) (User, error) {
+	var peek struct {
+		Issuer string `json:"iss"`
+	}
+	if parts := strings.Split(token, "."); len(parts) == 3 {
+		decodeSegment(parts[1], &peek)
+	}
+
+	p := providerByIssuer(strings.TrimSuffix(peek.Issuer, "/"))
+	if p == nil {
+		if ProviderByName(CognitoProvider) == nil {
+			return User{}, ErrInvalidToken
+		}
+		return FromCognito(ctx, token)
+	}
+
+	if _, err := p.metadata(ctx); err != nil {
+		return User{}, err
+	}
+	c, err := p.verifier.Verify(ctx, token)
+	if err != nil {
+		return User{}, err
+	}
+	return p.user(ctx, c)
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ScopeFull grants access to everything. It's what credentials issued before
+// scopes existed carry, and what a credential with no scopes is treated as.
+const ScopeFull = "justlog"
+
+// Scopes that can be granted to API keys and OAuth clients. Write scopes
+// include the matching read scope, and the entries scopes cover every entry
+// type.
+const (
+	ScopeEntriesRead   = "entries:read"
+	ScopeEntriesWrite  = "entries:write"
+	ScopeFoodRead      = "food:read"
+	ScopeFoodWrite     = "food:write"
+	ScopeExerciseRead  = "exercise:read"
+	ScopeExerciseWrite = "exercise:write"
+	ScopeWeightRead    = "weight:read"
+	ScopeWeightWrite   = "weight:write"
+	ScopeProfileRead   = "profile:read"
+	ScopeProfileWrite  = "profile:write"
+)
+
+// Scopes lists every supported scope, for discovery documents and the UI.
+var Scopes = []string{
+	ScopeFull,
+	ScopeEntriesRead, ScopeEntriesWrite,
+	ScopeFoodRead, ScopeFoodWrite,
+	ScopeExerciseRead, ScopeExerciseWrite,
+	ScopeWeightRead, ScopeWeightWrite,
+	ScopeProfileRead, ScopeProfileWrite,
+}
+
+// ScopeDescriptions says what each scope lets a client do, for the consent
+// screen.
+var ScopeDescriptions = map[string]string{
+	ScopeFull:          "Read and change everything in your JustLog account",
+	ScopeEntriesRead:   "See your food, exercise and weight entries",
+	ScopeEntriesWrite:  "Log, edit and delete food, exercise and weight entries",
+	ScopeFoodRead:      "See your food entries",
+	ScopeFoodWrite:     "Log, edit and delete food entries",
+	ScopeExerciseRead:  "See your exercise entries",
+	ScopeExerciseWrite: "Log, edit and delete exercise entries",
+	ScopeWeightRead:    "See your weight entries",
+	ScopeWeightWrite:   "Log, edit and delete weight entries",
+	ScopeProfileRead:   "See your profile and goals",
+	ScopeProfileWrite:  "Change your profile and goals",
+}
+
+// ParseScopes splits a space- or comma-separated scope list and checks that
+// every scope is supported. An empty list means full access.
+func ParseScopes(s string) ([]string, error) {
+	var out []string
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if !slices.Contains(Scopes, f) {
+			return nil, fmt.Errorf("unsupported scope %q", f)
+		}
+		if !slices.Contains(out, f) {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// EntryScope returns the scope needed to read or write entries of one type.
+// An empty type means all types.
+func EntryScope(entryType string, write bool) string {
+	if entryType == "" {
+		entryType = "entries"
+	}
+	if write {
+		return entryType + ":write"
+	}
+	return entryType + ":read"
+}
+
+// FullAccess reports whether the user's credential is unrestricted.
+func (u User) FullAccess() bool {
+	return len(u.Scopes) == 0 || slices.Contains(u.Scopes, ScopeFull)
+}
+
+// Allows reports whether the user's credential grants a scope, directly or
+// through a broader one.
+func (u User) Allows(scope string) bool {
+	if u.FullAccess() || slices.Contains(u.Scopes, scope) {
+		return true
+	}
+	resource, action, ok := strings.Cut(scope, ":")
+	if !ok {
+		return false
+	}
+	if action == "read" && slices.Contains(u.Scopes, resource+":write") {
+		return true
+	}
+	switch resource {
+	case "food", "exercise", "weight":
+		return u.Allows("entries:" + action)
+	}
+	return false
+}
+
+// AllowsAll reports whether the user's credential grants every scope given.
+func (u User) AllowsAll(scopes ...string) bool {
+	for _, s := range scopes {
+		if !u.Allows(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Missing returns the first scope the user's credential lacks, or "".
+func (u User) Missing(scopes ...string) string {
+	for _, s := range scopes {
+		if !u.Allows(s) {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+	mcpauth "github.com/BrianLeishman/justlog.io/go/lambda/mcp/auth"
+)
+
+// needsConsent reports whether a signed-in session has to show the consent
+// screen. Device sessions always do, since the user code could have come
+// from someone else's device; others skip it when the user has already
+// agreed to every scope requested.
+func needsConsent(r *http.Request, session *dynamo.AuthSession) (bool, error) {
+	if session.UserCode != "" {
+		return true, nil
+	}
+	consented, err := dynamo.GetConsent(r.Context(), session.UID, session.ClientID)
+	if err != nil || len(consented) == 0 {
+		return true, err
+	}
+	return !mcpauth.User{Scopes: consented}.AllowsAll(strings.Fields(session.Scope)...), nil
+}
+
+// renderConsent shows what a client is asking for and lets the user allow
+// or deny it. Device sessions also show their user code, so a user who was
+// sent someone else's code can see it doesn't match their device.
+func renderConsent(w http.ResponseWriter, r *http.Request, session *dynamo.AuthSession) {
+	client, err := dynamo.GetOAuthClient(r.Context(), session.ClientID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "get oauth client", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if client == nil {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+
+	token, err := dynamo.StartConsent(r.Context(), *session)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "start consent", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	var redirectHost string
+	if u, err := url.Parse(session.RedirectURI); err == nil {
+		redirectHost = u.Host
+	}
+	var scopes []string
+	for _, s := range strings.Fields(session.Scope) {
+		if d, ok := mcpauth.ScopeDescriptions[s]; ok {
+			scopes = append(scopes, d)
+		}
+	}
+
+	renderPage(w, http.StatusOK, "consent", map[string]any{
+		"SessionID":    session.SessionID,
+		"Token":        token,
+		"ClientName":   client.ClientName,
+		"RedirectHost": redirectHost,
+		"Scopes":       scopes,
+		"CanRemember":  session.UserCode == "",
+		"UserCode":     session.UserCode,
+	})
+}
+
+// handleConsent takes the answer to the consent screen.
+func handleConsent(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	session, err := dynamo.FinishConsent(r.Context(), r.PostFormValue("session_id"), r.PostFormValue("consent_token"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "finish consent", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if session == nil {
+		renderMessage(w, http.StatusBadRequest, "Request expired", "This request has expired or was already answered. Start again from the app.")
+		return
+	}
+
+	allow := r.PostFormValue("decision") == "allow"
+	if session.UserCode != "" {
+		completeDevice(w, r, session, session.UID, allow)
+		return
+	}
+	if !allow {
+		authorizeError(w, r, session.RedirectURI, session.State, "access_denied", "the user denied the request")
+		return
+	}
+	if r.PostFormValue("remember") != "" {
+		if err := dynamo.AddConsent(r.Context(), session.UID, session.ClientID, strings.Fields(session.Scope)); err != nil {
+			// Not remembering only means asking again next time
+			slog.ErrorContext(r.Context(), "add consent", "err", err)
+		}
+	}
+	issueAuthCode(w, r, session)
+}
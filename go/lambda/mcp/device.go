@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+	mcpauth "github.com/BrianLeishman/justlog.io/go/lambda/mcp/auth"
+	"github.com/google/uuid"
+)
+
+// grantTypeDeviceCode is the RFC 8628 device authorization grant.
+const grantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// handleDeviceAuthorization starts an RFC 8628 device authorization. The
+// client shows the user code and verification URI, then polls the token
+// endpoint with the device code while the user signs in on another device.
+func handleDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+	clientID := r.FormValue("client_id")
+	if clientID == "" {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "client_id is required")
+		return
+	}
+
+	client, err := dynamo.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "get oauth client", "err", err)
+		tokenError(w, http.StatusInternalServerError, "server_error", "internal error")
+		return
+	}
+	if client == nil {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "unknown client_id")
+		return
+	}
+	if !slices.Contains(client.GrantTypes, grantTypeDeviceCode) {
+		tokenError(w, http.StatusBadRequest, "unauthorized_client", "client is not registered for the device_code grant")
+		return
+	}
+
+	scopes, err := clientScopes(client, r.FormValue("scope"))
+	if err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+		return
+	}
+
+	d, err := dynamo.CreateDeviceAuthorization(r.Context(), clientID, strings.Join(scopes, " "))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "create device authorization", "err", err)
+		tokenError(w, http.StatusInternalServerError, "server_error", "internal error")
+		return
+	}
+
+	verificationURI := cfg.BaseURL + "/oauth/device"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{
+		"device_code":               d.DeviceCode,
+		"user_code":                 d.UserCode,
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?" + url.Values{"user_code": {d.UserCode}}.Encode(),
+		"expires_in":                int(dynamo.DeviceCodeTTL.Seconds()),
+		"interval":                  int(dynamo.DevicePollInterval.Seconds()),
+	})
+}
+
+// handleDevice is the verification page. It asks for the user code, then
+// sends the user through the same identity provider login as the authorize
+// endpoint; the callback approves the device.
+func handleDevice(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	userCode := q.Get("user_code")
+	if userCode == "" {
+		renderPage(w, http.StatusOK, "device", map[string]string{})
+		return
+	}
+
+	d, err := dynamo.GetDeviceAuthorization(r.Context(), userCode)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "get device authorization", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if d == nil {
+		renderPage(w, http.StatusBadRequest, "device", map[string]string{
+			"UserCode": userCode,
+			"Error":    "That code is invalid or has expired.",
+		})
+		return
+	}
+
+	providers := mcpauth.Providers()
+	idp := q.Get("idp")
+	if idp == "" && len(providers) > 1 {
+		renderProviderChooser(w, r, providers)
+		return
+	}
+	provider := providers[0]
+	if idp != "" {
+		if provider = mcpauth.ProviderByName(idp); provider == nil {
+			http.Error(w, "unknown identity provider", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sessionID := uuid.New().String()
+	err = dynamo.PutAuthSession(r.Context(), dynamo.AuthSession{
+		SessionID: sessionID,
+		ClientID:  d.ClientID,
+		Scope:     d.Scope,
+		Provider:  provider.Name,
+		UserCode:  d.UserCode,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "put auth session", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	loginURL, err := provider.AuthCodeURL(r.Context(), sessionID, cfg.BaseURL+"/oauth/callback")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "identity provider", "err", err)
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	http.Redirect(w, r, loginURL, http.StatusFound)
+}
+
+// completeDevice records the outcome of a device session's login and tells
+// the user whether to go back to their device.
+func completeDevice(w http.ResponseWriter, r *http.Request, session *dynamo.AuthSession, uid string, approve bool) {
+	err := dynamo.CompleteDeviceAuthorization(r.Context(), session.UserCode, uid, approve)
+	switch {
+	case errors.Is(err, dynamo.ErrInvalidGrant):
+		renderMessage(w, http.StatusBadRequest, "Code expired", "This code has expired or was already used. Start again on your device.")
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "complete device authorization", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !approve {
+		renderMessage(w, http.StatusOK, "Device not connected", "Sign-in was cancelled, so the device wasn't connected.")
+		return
+	}
+
+	name := "Your device"
+	if c, err := dynamo.GetOAuthClient(r.Context(), session.ClientID); err == nil && c != nil && c.ClientName != "" {
+		name = c.ClientName
+	}
+	renderMessage(w, http.StatusOK, "Device connected", name+" is connected to JustLog. You can close this page and return to your device.")
+}
+
+func handleTokenDeviceCode(w http.ResponseWriter, r *http.Request) {
+	deviceCode := r.FormValue("device_code")
+	clientID := r.FormValue("client_id")
+	if deviceCode == "" || clientID == "" {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "device_code and client_id are required")
+		return
+	}
+
+	d, err := dynamo.PollDeviceAuthorization(r.Context(), deviceCode)
+	switch {
+	case errors.Is(err, dynamo.ErrSlowDown):
+		tokenError(w, http.StatusBadRequest, "slow_down", "polling too fast")
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "poll device authorization", "err", err)
+		tokenError(w, http.StatusInternalServerError, "server_error", "internal error")
+		return
+	case d == nil:
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "invalid device_code")
+		return
+	case d.ClientID != clientID:
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "client_id mismatch")
+		return
+	case time.Now().After(d.ExpiresAt):
+		tokenError(w, http.StatusBadRequest, "expired_token", "device_code has expired")
+		return
+	}
+
+	switch d.Status {
+	case dynamo.DeviceStatusPending:
+		tokenError(w, http.StatusBadRequest, "authorization_pending", "the user hasn't finished signing in")
+		return
+	case dynamo.DeviceStatusDenied:
+		tokenError(w, http.StatusBadRequest, "access_denied", "the user denied the request")
+		return
+	}
+
+	err = dynamo.RedeemDeviceAuthorization(r.Context(), deviceCode)
+	if errors.Is(err, dynamo.ErrInvalidGrant) {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "device_code was already used")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "redeem device authorization", "err", err)
+		tokenError(w, http.StatusInternalServerError, "server_error", "internal error")
+		return
+	}
+
+	pair, err := dynamo.CreateGrant(r.Context(), d.UID, clientID, d.Scope)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "create grant", "err", err)
+		tokenError(w, http.StatusInternalServerError, "server_error", "internal error")
+		return
+	}
+	audit(r, d.UID, dynamo.AuditEvent{Type: dynamo.AuditTokenIssued, ClientID: clientID, Detail: "device_code grant for " + d.Scope})
+	writeTokens(w, pair)
+}
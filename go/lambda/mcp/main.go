@@ -6,20 +6,38 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 	_ "time/tzdata"
 
+	"github.com/BrianLeishman/justlog.io/go/config"
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
 	mcpauth "github.com/BrianLeishman/justlog.io/go/lambda/mcp/auth"
 	"github.com/BrianLeishman/justlog.io/go/lambda/mcp/tools"
+	"github.com/BrianLeishman/justlog.io/go/logging"
+	"github.com/BrianLeishman/justlog.io/go/ratelimit"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/awslabs/aws-lambda-go-api-proxy/httpadapter"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
 
+// cfg is the deployment configuration, loaded and validated at startup.
+var cfg *config.Config
+
 func main() {
+	// stdout carries the protocol in stdio mode, so logs go to stderr
+	logging.Setup(os.Stderr)
+
+	var err error
+	if cfg, err = config.Load(); err != nil {
+		log.Fatal(err)
+	}
+
 	isLambda := os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != ""
 
 	mcpServer := server.NewMCPServer(
@@ -50,10 +68,12 @@ func main() {
 		mux := http.NewServeMux()
 
 		// OpenAI domain verification
-		mux.HandleFunc("GET /.well-known/openai-apps-challenge", func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "text/plain")
-			w.Write([]byte("RiotatjG6D-VQ-7RnzdYBxIeWm8ZKSYTlDjxxIJupT4"))
-		})
+		if cfg.OpenAIChallenge != "" {
+			mux.HandleFunc("GET /.well-known/openai-apps-challenge", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte(cfg.OpenAIChallenge))
+			})
+		}
 
 		// OAuth discovery
 		mux.HandleFunc("GET /.well-known/oauth-protected-resource", handleProtectedResource)
@@ -62,8 +82,15 @@ func main() {
 		// OAuth flow
 		mux.HandleFunc("GET /oauth/authorize", handleAuthorize)
 		mux.HandleFunc("GET /oauth/callback", handleCallback)
+		mux.HandleFunc("POST /oauth/consent", handleConsent)
 		mux.HandleFunc("POST /oauth/token", handleToken)
 		mux.HandleFunc("POST /oauth/register", handleRegister)
+		mux.HandleFunc("GET /oauth/register/{client_id}", handleGetRegistration)
+		mux.HandleFunc("DELETE /oauth/register/{client_id}", handleDeleteRegistration)
+		mux.HandleFunc("POST /oauth/revoke", handleRevoke)
+		mux.HandleFunc("POST /oauth/introspect", handleIntrospect)
+		mux.HandleFunc("POST /oauth/device_authorization", handleDeviceAuthorization)
+		mux.HandleFunc("GET /oauth/device", handleDevice)
 
 		// MCP JSON-RPC
 		mux.HandleFunc("POST /mcp", func(w http.ResponseWriter, r *http.Request) {
@@ -77,11 +104,10 @@ func main() {
 
 		// Default: 404
 		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			log.Printf("unhandled: %s %s", r.Method, r.URL.Path)
 			w.WriteHeader(http.StatusNotFound)
 		})
 
-		adapter := httpadapter.NewV2(mux)
+		adapter := httpadapter.NewV2(withAuditIP(logging.Handler(mux)))
 		lambda.Start(adapter.ProxyWithContext)
 	} else {
 		// Local: use StreamableHTTPServer for full MCP protocol support
@@ -91,23 +117,25 @@ func main() {
 			server.WithHTTPContextFunc(authenticateRequest),
 		)
 
-		addr := ":8088"
-		fmt.Printf("MCP server listening on %s\n", addr)
-		if err := httpServer.Start(addr); err != nil {
+		// Served through our own mux so requests get IDs and per-user
+		// debug logging, as they do in Lambda
+		mux := http.NewServeMux()
+		mux.Handle("/mcp", httpServer)
+
+		fmt.Printf("MCP server listening on %s\n", cfg.MCPAddr)
+		if err := http.ListenAndServe(cfg.MCPAddr, withAuditIP(logging.Handler(mux))); err != nil {
 			log.Fatal(err)
 		}
 	}
 }
 
 func handleMCP(w http.ResponseWriter, r *http.Request, mcpServer *server.MCPServer) {
-	log.Printf("request: %s %s", r.Method, r.URL.Path)
-
 	ctx := r.Context()
 	ctx = authenticateRequest(ctx, r)
 
 	// Check auth — return 401 with WWW-Authenticate if not authenticated
 	if _, err := mcpauth.FromContext(ctx); err != nil {
-		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer resource_metadata="%s/.well-known/oauth-protected-resource"`, baseURL))
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer resource_metadata="%s/.well-known/oauth-protected-resource"`, cfg.BaseURL))
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -118,17 +146,46 @@ func handleMCP(w http.ResponseWriter, r *http.Request, mcpServer *server.MCPServ
 		return
 	}
 
-	log.Printf("request body: %s", body)
+	// Bodies hold what users log, so they're only logged at debug level,
+	// which is only on for debug_users; everyone else gets the method and
+	// tool
+	var msg struct {
+		Method string `json:"method"`
+		Params struct {
+			Name string `json:"name"`
+		} `json:"params"`
+	}
+	json.Unmarshal(body, &msg)
+	attrs := []any{"method", msg.Method}
+	if msg.Params.Name != "" {
+		attrs = append(attrs, "tool", msg.Params.Name)
+	}
+	slog.DebugContext(ctx, "mcp request", append(attrs, "body", json.RawMessage(body))...)
 
 	resp := mcpServer.HandleMessage(ctx, body)
 	w.Header().Set("Content-Type", "application/json")
 	out, _ := json.Marshal(resp)
-	log.Printf("response: %s", out)
 	w.Write(out)
+
+	outcome := "ok"
+	if e, ok := resp.(mcp.JSONRPCError); ok {
+		outcome = strconv.Itoa(e.Error.Code)
+	}
+	slog.InfoContext(ctx, "mcp", append(attrs, "outcome", outcome)...)
+	slog.DebugContext(ctx, "mcp response", "response", json.RawMessage(out))
+}
+
+// withAuditIP notes the client's address for audit events recorded while
+// handling the request.
+func withAuditIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(dynamo.WithAuditIP(r.Context(), clientIP(r))))
+	})
 }
 
 func authenticateRequest(ctx context.Context, r *http.Request) context.Context {
 	if devUser := os.Getenv("DEV_USER"); devUser != "" {
+		logging.SetUser(ctx, devUser)
 		return mcpauth.NewContext(ctx, mcpauth.User{Sub: devUser, Email: devUser})
 	}
 
@@ -138,25 +195,50 @@ func authenticateRequest(ctx context.Context, r *http.Request) context.Context {
 	}
 	u, err := mcpauth.FromToken(ctx, token)
 	if err != nil {
-		log.Printf("auth error: %v", err)
+		slog.WarnContext(ctx, "auth error", "err", err)
 		return ctx
 	}
 	return mcpauth.NewContext(ctx, u)
 }
 
+// wrapTool turns panics and errors from a tool into tool errors, applies
+// rate limits, and logs each call with its latency and outcome.
 func wrapTool(fn server.ToolHandlerFunc) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (result *mcp.CallToolResult, err error) {
+		start := time.Now()
+		outcome := "ok"
 		defer func() {
 			if r := recover(); r != nil {
+				slog.ErrorContext(ctx, "tool panic", "tool", req.Params.Name, "panic", fmt.Sprint(r))
 				result = mcp.NewToolResultError(fmt.Sprintf("internal error: %v", r))
 				err = nil
+				outcome = "panic"
 			}
+			slog.InfoContext(ctx, "tool call",
+				"tool", req.Params.Name,
+				"outcome", outcome,
+				"duration_ms", time.Since(start).Milliseconds(),
+			)
+			slog.DebugContext(ctx, "tool result", "tool", req.Params.Name, "arguments", req.GetArguments(), "result", result)
 		}()
 
+		// Tool calls are what cost DynamoDB reads, so they're what's
+		// limited. The error tells the assistant to back off.
+		if u, err := mcpauth.FromContext(ctx); err == nil {
+			if wait := ratelimit.Check(ctx, u); wait > 0 {
+				outcome = "rate_limited"
+				return mcp.NewToolResultError(fmt.Sprintf("rate limit exceeded: too many requests, try again in %s seconds", ratelimit.RetryAfter(wait))), nil
+			}
+		}
+
 		result, err = fn(ctx, req)
 		if err != nil {
+			outcome = "error"
 			return mcp.NewToolResultError(err.Error()), nil
 		}
+		if result != nil && result.IsError {
+			outcome = "error"
+		}
 		return result, nil
 	}
 }
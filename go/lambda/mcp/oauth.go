@@ -1,13 +1,17 @@
 package main
 
 import (
+	"cmp"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
@@ -16,35 +20,76 @@ import (
 	"github.com/google/uuid"
 )
 
-const (
-	baseURL       = "https://k24xsd279c.execute-api.us-east-1.amazonaws.com"
-	cognitoDomain = "https://justlog.auth.us-east-1.amazoncognito.com"
-	cognitoClient = "11h4ggbj2m9hehirq0n7hcq5m8"
-)
-
 func handleProtectedResource(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
-		"resource":              baseURL,
-		"authorization_servers": []string{baseURL},
-		"scopes_supported":      []string{"justlog"},
+		"resource":              cfg.BaseURL,
+		"authorization_servers": []string{cfg.BaseURL},
+		"scopes_supported":      mcpauth.Scopes,
 	})
 }
 
 func handleAuthServerMeta(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
-		"issuer":                           baseURL,
-		"authorization_endpoint":           baseURL + "/oauth/authorize",
-		"token_endpoint":                   baseURL + "/oauth/token",
-		"registration_endpoint":            baseURL + "/oauth/register",
-		"response_types_supported":         []string{"code"},
-		"grant_types_supported":            []string{"authorization_code", "refresh_token"},
-		"code_challenge_methods_supported": []string{"S256"},
-		"scopes_supported":                 []string{"justlog"},
+		"issuer":                                     cfg.BaseURL,
+		"authorization_endpoint":                     cfg.BaseURL + "/oauth/authorize",
+		"token_endpoint":                             cfg.BaseURL + "/oauth/token",
+		"registration_endpoint":                      cfg.BaseURL + "/oauth/register",
+		"device_authorization_endpoint":              cfg.BaseURL + "/oauth/device_authorization",
+		"revocation_endpoint":                        cfg.BaseURL + "/oauth/revoke",
+		"introspection_endpoint":                     cfg.BaseURL + "/oauth/introspect",
+		"response_types_supported":                   []string{"code"},
+		"grant_types_supported":                      []string{"authorization_code", "refresh_token", grantTypeDeviceCode},
+		"code_challenge_methods_supported":           []string{"S256"},
+		"token_endpoint_auth_methods_supported":      []string{"none"},
+		"revocation_endpoint_auth_methods_supported": []string{"none"},
+		"scopes_supported":                           mcpauth.Scopes,
 	})
 }
 
+// validRedirectURI checks a redirect URI against the registration policy:
+// absolute, no fragment, and either https or http on a loopback address for
+// native clients.
+func validRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute URL", raw)
+	}
+	if u.Fragment != "" || strings.Contains(raw, "#") {
+		return fmt.Errorf("%q must not contain a fragment", raw)
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+		return fmt.Errorf("%q must use https unless it points at a loopback address", raw)
+	}
+	return fmt.Errorf("%q must use https or loopback http", raw)
+}
+
+// authorizeError sends an RFC 6749 error response back to the client's
+// redirect URI. Only call this once the redirect URI has been verified.
+func authorizeError(w http.ResponseWriter, r *http.Request, redirectURI, state, code, description string) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	q := u.Query()
+	q.Set("error", code)
+	q.Set("error_description", description)
+	if state != "" {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
 func handleAuthorize(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	clientID := q.Get("client_id")
@@ -53,19 +98,15 @@ func handleAuthorize(w http.ResponseWriter, r *http.Request) {
 	codeChallengeMethod := q.Get("code_challenge_method")
 	state := q.Get("state")
 
-	if clientID == "" || redirectURI == "" || codeChallenge == "" || state == "" {
-		http.Error(w, "missing required parameters", http.StatusBadRequest)
+	// Until the client and redirect URI check out, errors go to the user
+	// rather than being redirected anywhere.
+	if clientID == "" || redirectURI == "" {
+		http.Error(w, "client_id and redirect_uri are required", http.StatusBadRequest)
 		return
 	}
-	if codeChallengeMethod != "" && codeChallengeMethod != "S256" {
-		http.Error(w, "unsupported code_challenge_method", http.StatusBadRequest)
-		return
-	}
-
-	// Verify client exists
 	client, err := dynamo.GetOAuthClient(r.Context(), clientID)
 	if err != nil {
-		log.Printf("get oauth client: %v", err)
+		slog.ErrorContext(r.Context(), "get oauth client", "err", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
@@ -73,6 +114,48 @@ func handleAuthorize(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "unknown client_id", http.StatusBadRequest)
 		return
 	}
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		http.Error(w, "redirect_uri is not registered for this client", http.StatusBadRequest)
+		return
+	}
+
+	if rt := q.Get("response_type"); rt != "code" {
+		authorizeError(w, r, redirectURI, state, "unsupported_response_type", "response_type must be code")
+		return
+	}
+	if codeChallenge == "" {
+		authorizeError(w, r, redirectURI, state, "invalid_request", "code_challenge is required")
+		return
+	}
+	if codeChallengeMethod != "S256" {
+		authorizeError(w, r, redirectURI, state, "invalid_request", "code_challenge_method must be S256")
+		return
+	}
+	if state == "" {
+		authorizeError(w, r, redirectURI, state, "invalid_request", "state is required")
+		return
+	}
+	scopes, err := clientScopes(client, q.Get("scope"))
+	if err != nil {
+		authorizeError(w, r, redirectURI, state, "invalid_scope", err.Error())
+		return
+	}
+
+	// With several identity providers and none picked yet, let the user
+	// choose; the chooser links back here with idp set.
+	providers := mcpauth.Providers()
+	idp := q.Get("idp")
+	if idp == "" && len(providers) > 1 {
+		renderProviderChooser(w, r, providers)
+		return
+	}
+	provider := providers[0]
+	if idp != "" {
+		if provider = mcpauth.ProviderByName(idp); provider == nil {
+			authorizeError(w, r, redirectURI, state, "invalid_request", "unknown identity provider")
+			return
+		}
+	}
 
 	// Create auth session
 	sessionID := uuid.New().String()
@@ -82,30 +165,46 @@ func handleAuthorize(w http.ResponseWriter, r *http.Request) {
 		RedirectURI:   redirectURI,
 		CodeChallenge: codeChallenge,
 		State:         state,
+		Scope:         strings.Join(scopes, " "),
+		Provider:      provider.Name,
 		CreatedAt:     time.Now().UTC().Format(time.RFC3339),
 	})
 	if err != nil {
-		log.Printf("put auth session: %v", err)
+		slog.ErrorContext(r.Context(), "put auth session", "err", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	// Redirect to Cognito, encoding our session ID in the state
-	cognitoParams := url.Values{
-		"client_id":     {cognitoClient},
-		"response_type": {"code"},
-		"scope":         {"openid email profile"},
-		"redirect_uri":  {baseURL + "/oauth/callback"},
-		"state":         {sessionID},
+	// Redirect to the identity provider, encoding our session ID in the state
+	loginURL, err := provider.AuthCodeURL(r.Context(), sessionID, cfg.BaseURL+"/oauth/callback")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "identity provider", "err", err)
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
 	}
-	http.Redirect(w, r, cognitoDomain+"/oauth2/authorize?"+cognitoParams.Encode(), http.StatusFound)
+	http.Redirect(w, r, loginURL, http.StatusFound)
+}
+
+// renderProviderChooser shows a page linking to this authorize request once
+// per identity provider.
+func renderProviderChooser(w http.ResponseWriter, r *http.Request, providers []*mcpauth.Provider) {
+	type choice struct{ Title, URL string }
+	choices := make([]choice, 0, len(providers))
+	for _, p := range providers {
+		u := *r.URL
+		q := u.Query()
+		q.Set("idp", p.Name)
+		u.RawQuery = q.Encode()
+		choices = append(choices, choice{Title: p.Title, URL: u.RequestURI()})
+	}
+	renderPage(w, http.StatusOK, "chooser", choices)
 }
 
 func handleCallback(w http.ResponseWriter, r *http.Request) {
 	code := r.URL.Query().Get("code")
 	sessionID := r.URL.Query().Get("state")
-	if code == "" || sessionID == "" {
-		http.Error(w, "missing code or state", http.StatusBadRequest)
+	if sessionID == "" {
+		http.Error(w, "missing state", http.StatusBadRequest)
 		return
 	}
 
@@ -115,60 +214,57 @@ func handleCallback(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid session", http.StatusBadRequest)
 		return
 	}
-
-	// Exchange code with Cognito
-	body := url.Values{
-		"grant_type":   {"authorization_code"},
-		"client_id":    {cognitoClient},
-		"code":         {code},
-		"redirect_uri": {baseURL + "/oauth/callback"},
-	}
-	resp, err := http.Post(cognitoDomain+"/oauth2/token", "application/x-www-form-urlencoded", strings.NewReader(body.Encode()))
-	if err != nil {
-		log.Printf("cognito token exchange: %v", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	if code == "" {
+		// The user cancelled or the identity provider refused the login
+		if session.UserCode != "" {
+			completeDevice(w, r, session, "", false)
+			return
+		}
+		authorizeError(w, r, session.RedirectURI, session.State, "access_denied", "the user did not sign in")
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("cognito token returned %d", resp.StatusCode)
-		http.Error(w, "cognito auth failed", http.StatusBadGateway)
+	provider := mcpauth.ProviderByName(cmp.Or(session.Provider, mcpauth.CognitoProvider))
+	if provider == nil {
+		http.Error(w, "identity provider is no longer configured", http.StatusBadRequest)
 		return
 	}
-
-	var tokens struct {
-		AccessToken  string `json:"access_token"`
-		RefreshToken string `json:"refresh_token"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
-		log.Printf("decode cognito tokens: %v", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	user, err := provider.Exchange(r.Context(), code, cfg.BaseURL+"/oauth/callback")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "sign-in", "err", err)
+		http.Error(w, "sign-in failed", http.StatusBadGateway)
 		return
 	}
 
-	// Get user info from Cognito
-	u, err := mcpauth.FromCognito(r.Context(), tokens.AccessToken)
+	session.UID = user.Sub
+
+	ask, err := needsConsent(r, session)
 	if err != nil {
-		log.Printf("cognito userinfo: %v", err)
+		slog.ErrorContext(r.Context(), "get consent", "err", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	if ask {
+		renderConsent(w, r, session)
+		return
+	}
+	issueAuthCode(w, r, session)
+}
 
-	// Generate our authorization code
-	ourCode := uuid.New().String()
-	err = dynamo.PutAuthCode(r.Context(), dynamo.AuthCode{
-		Code:                ourCode,
-		SessionID:           sessionID,
-		UID:                 u.Sub,
-		CognitoAccessToken:  tokens.AccessToken,
-		CognitoRefreshToken: tokens.RefreshToken,
-		CodeChallenge:       session.CodeChallenge,
-		ClientID:            session.ClientID,
-		RedirectURI:         session.RedirectURI,
-	})
-	if err != nil {
-		log.Printf("put auth code: %v", err)
+// issueAuthCode sends a signed-in, consenting user back to the client with
+// an authorization code.
+func issueAuthCode(w http.ResponseWriter, r *http.Request, session *dynamo.AuthSession) {
+	ac := dynamo.AuthCode{
+		Code:          uuid.New().String(),
+		SessionID:     session.SessionID,
+		UID:           session.UID,
+		CodeChallenge: session.CodeChallenge,
+		ClientID:      session.ClientID,
+		RedirectURI:   session.RedirectURI,
+		Scope:         session.Scope,
+	}
+	if err := dynamo.PutAuthCode(r.Context(), ac); err != nil {
+		slog.ErrorContext(r.Context(), "put auth code", "err", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
@@ -180,15 +276,48 @@ func handleCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	q := redirectURL.Query()
-	q.Set("code", ourCode)
+	q.Set("code", ac.Code)
 	q.Set("state", session.State)
 	redirectURL.RawQuery = q.Encode()
 	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
 }
 
+// audit records an event, logging rather than failing the request if it
+// can't.
+func audit(r *http.Request, uid string, e dynamo.AuditEvent) {
+	if err := dynamo.RecordAudit(r.Context(), uid, e); err != nil {
+		slog.ErrorContext(r.Context(), "record audit event", "type", e.Type, "err", err)
+	}
+}
+
+// tokenError writes an RFC 6749 section 5.2 error response.
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// writeTokens writes a successful token response for a freshly issued pair.
+func writeTokens(w http.ResponseWriter, pair dynamo.TokenPair) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token":  pair.AccessToken,
+		"token_type":    "Bearer",
+		"expires_in":    pair.ExpiresIn,
+		"refresh_token": pair.RefreshToken,
+		"scope":         pair.Scope,
+	})
+}
+
 func handleToken(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		tokenError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
 		return
 	}
 
@@ -199,8 +328,10 @@ func handleToken(w http.ResponseWriter, r *http.Request) {
 		handleTokenAuthCode(w, r)
 	case "refresh_token":
 		handleTokenRefresh(w, r)
+	case grantTypeDeviceCode:
+		handleTokenDeviceCode(w, r)
 	default:
-		http.Error(w, "unsupported grant_type", http.StatusBadRequest)
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code, refresh_token or "+grantTypeDeviceCode)
 	}
 }
 
@@ -210,14 +341,23 @@ func handleTokenAuthCode(w http.ResponseWriter, r *http.Request) {
 	clientID := r.FormValue("client_id")
 	redirectURI := r.FormValue("redirect_uri")
 
-	if code == "" || codeVerifier == "" || clientID == "" {
-		http.Error(w, "missing required parameters", http.StatusBadRequest)
+	if code == "" || codeVerifier == "" || clientID == "" || redirectURI == "" {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "code, code_verifier, client_id and redirect_uri are required")
 		return
 	}
 
-	ac, err := dynamo.GetAuthCode(r.Context(), code)
-	if err != nil || ac == nil {
-		http.Error(w, "invalid code", http.StatusBadRequest)
+	ac, err := dynamo.RedeemAuthCode(r.Context(), code)
+	switch {
+	case errors.Is(err, dynamo.ErrCodeReuse):
+		slog.WarnContext(r.Context(), "authorization code reuse, grant revoked", "client_id", clientID)
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "authorization code has already been used")
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "redeem auth code", "err", err)
+		tokenError(w, http.StatusInternalServerError, "server_error", "internal error")
+		return
+	case ac == nil:
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "invalid or expired code")
 		return
 	}
 
@@ -225,132 +365,169 @@ func handleTokenAuthCode(w http.ResponseWriter, r *http.Request) {
 	h := sha256.Sum256([]byte(codeVerifier))
 	expectedChallenge := base64.RawURLEncoding.EncodeToString(h[:])
 	if expectedChallenge != ac.CodeChallenge {
-		http.Error(w, "invalid code_verifier", http.StatusBadRequest)
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "invalid code_verifier")
 		return
 	}
 
 	// Validate client_id and redirect_uri
 	if ac.ClientID != clientID {
-		http.Error(w, "client_id mismatch", http.StatusBadRequest)
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "client_id mismatch")
 		return
 	}
-	if redirectURI != "" && ac.RedirectURI != redirectURI {
-		http.Error(w, "redirect_uri mismatch", http.StatusBadRequest)
+	if ac.RedirectURI != redirectURI {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri mismatch")
 		return
 	}
 
-	// Create an API key for the user
-	label := fmt.Sprintf("OAuth: %s", clientID)
-	rawKey, _, err := dynamo.CreateAPIKey(r.Context(), ac.UID, label)
+	scope := ac.Scope
+	if scope == "" {
+		scope = mcpauth.ScopeFull
+	}
+	pair, err := dynamo.CreateGrantForCode(r.Context(), ac, scope)
+	if errors.Is(err, dynamo.ErrCodeReuse) {
+		slog.WarnContext(r.Context(), "authorization code reused before its grant was written", "client_id", clientID)
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "authorization code has already been used")
+		return
+	}
 	if err != nil {
-		log.Printf("create api key: %v", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "create grant", "err", err)
+		tokenError(w, http.StatusInternalServerError, "server_error", "internal error")
 		return
 	}
+	audit(r, ac.UID, dynamo.AuditEvent{Type: dynamo.AuditTokenIssued, ClientID: clientID, Detail: "authorization_code grant for " + scope})
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"access_token": rawKey,
-		"token_type":   "Bearer",
-	})
+	writeTokens(w, pair)
 }
 
 func handleTokenRefresh(w http.ResponseWriter, r *http.Request) {
 	refreshToken := r.FormValue("refresh_token")
 	if refreshToken == "" {
-		http.Error(w, "missing refresh_token", http.StatusBadRequest)
+		tokenError(w, http.StatusBadRequest, "invalid_request", "refresh_token is required")
 		return
 	}
 
-	// Exchange refresh token with Cognito
-	body := url.Values{
-		"grant_type":    {"refresh_token"},
-		"client_id":     {cognitoClient},
-		"refresh_token": {refreshToken},
-	}
-	resp, err := http.Post(cognitoDomain+"/oauth2/token", "application/x-www-form-urlencoded", strings.NewReader(body.Encode()))
-	if err != nil {
-		log.Printf("cognito refresh: %v", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	g, pair, err := dynamo.RefreshGrant(r.Context(), refreshToken, r.FormValue("client_id"))
+	switch {
+	case errors.Is(err, dynamo.ErrTokenReuse):
+		slog.WarnContext(r.Context(), "refresh token reuse, grant revoked")
+		audit(r, g.UID, dynamo.AuditEvent{Type: dynamo.AuditRefreshReuse, ClientID: g.ClientID, Detail: "grant revoked"})
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "refresh token has already been used")
 		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		http.Error(w, "refresh failed", http.StatusUnauthorized)
+	case errors.Is(err, dynamo.ErrInvalidGrant):
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "invalid or expired refresh token")
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "refresh grant", "err", err)
+		tokenError(w, http.StatusInternalServerError, "server_error", "internal error")
 		return
 	}
 
-	var tokens struct {
-		AccessToken string `json:"access_token"`
+	audit(r, g.UID, dynamo.AuditEvent{Type: dynamo.AuditTokenIssued, ClientID: g.ClientID, Detail: "refresh_token grant for " + pair.Scope})
+	writeTokens(w, pair)
+}
+
+// inspectRequestToken parses a revocation or introspection request and looks
+// up its token. Clients are public, so possession of the token is what
+// authorizes a revocation; a client_id, if sent, has to match the client the
+// token was issued to.
+func inspectRequestToken(w http.ResponseWriter, r *http.Request) (*dynamo.TokenInfo, bool) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "invalid form body")
+		return nil, false
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
-		return
+	token := r.FormValue("token")
+	if token == "" {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return nil, false
 	}
 
-	// Get user from new access token
-	u, err := mcpauth.FromCognito(r.Context(), tokens.AccessToken)
+	info, err := dynamo.InspectToken(r.Context(), token, r.FormValue("token_type_hint"))
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
-		return
+		slog.ErrorContext(r.Context(), "inspect token", "err", err)
+		tokenError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "try again later")
+		return nil, false
 	}
-
-	// Create a fresh API key
-	label := "OAuth: refresh"
-	rawKey, _, err := dynamo.CreateAPIKey(r.Context(), u.Sub, label)
-	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
-		return
+	if info != nil {
+		if clientID := r.FormValue("client_id"); clientID != "" && info.ClientID != "" && clientID != info.ClientID {
+			info = nil
+		}
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"access_token": rawKey,
-		"token_type":   "Bearer",
-	})
+	return info, true
 }
 
-func handleRegister(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		ClientName   string   `json:"client_name"`
-		RedirectURIs []string `json:"redirect_uris"`
-		GrantTypes   []string `json:"grant_types"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+// handleRevoke implements RFC 7009 token revocation. Revoking an access or
+// refresh token ends its whole grant; revoking an API key deletes it.
+// Unknown tokens still get a 200, as the RFC requires.
+func handleRevoke(w http.ResponseWriter, r *http.Request) {
+	info, ok := inspectRequestToken(w, r)
+	if !ok {
 		return
 	}
+	if info != nil {
+		if err := dynamo.RevokeToken(r.Context(), *info); err != nil {
+			slog.ErrorContext(r.Context(), "revoke token", "err", err)
+			tokenError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "try again later")
+			return
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
 
-	if len(req.RedirectURIs) == 0 {
-		http.Error(w, "redirect_uris required", http.StatusBadRequest)
+// handleIntrospect implements RFC 7662 token introspection. Callers
+// authenticate as a client with its registration access token as a Bearer
+// token and its client_id in the form, and only that client's own tokens
+// come back active.
+func handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "invalid form body")
 		return
 	}
-	if len(req.GrantTypes) == 0 {
-		req.GrantTypes = []string{"authorization_code", "refresh_token"}
+	clientID := r.FormValue("client_id")
+	c, err := dynamo.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "get oauth client", "err", err)
+		tokenError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "try again later")
+		return
 	}
-
-	clientID := uuid.New().String()
-	c := dynamo.OAuthClient{
-		ClientID:     clientID,
-		ClientName:   req.ClientName,
-		RedirectURIs: req.RedirectURIs,
-		GrantTypes:   req.GrantTypes,
-		CreatedAt:    time.Now().UTC().Format(time.RFC3339),
+	if clientID == "" || c == nil || !c.RegistrationTokenValid(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "introspection requires the client's registration access token")
+		return
 	}
 
-	if err := dynamo.PutOAuthClient(r.Context(), c); err != nil {
-		log.Printf("put oauth client: %v", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	info, ok := inspectRequestToken(w, r)
+	if !ok {
 		return
 	}
+	// API keys have no client, so this also leaves them out
+	if info != nil && info.ClientID != clientID {
+		info = nil
+	}
+
+	resp := map[string]any{"active": info != nil}
+	if info != nil {
+		resp["sub"] = info.UID
+		resp["iss"] = cfg.BaseURL
+		resp["scope"] = info.Scope
+		if info.Scope == "" {
+			resp["scope"] = mcpauth.ScopeFull
+		}
+		if info.ClientID != "" {
+			resp["client_id"] = info.ClientID
+		}
+		if info.Kind != dynamo.TokenRefresh {
+			resp["token_type"] = "Bearer"
+		}
+		if !info.IssuedAt.IsZero() {
+			resp["iat"] = info.IssuedAt.Unix()
+		}
+		if !info.ExpiresAt.IsZero() {
+			resp["exp"] = info.ExpiresAt.Unix()
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]any{
-		"client_id":     clientID,
-		"client_name":   c.ClientName,
-		"redirect_uris": c.RedirectURIs,
-		"grant_types":   c.GrantTypes,
-	})
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
 }
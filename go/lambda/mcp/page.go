@@ -0,0 +1,87 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+)
+
+// pages are the few HTML pages the OAuth server shows users directly. Each
+// wraps its content in the shared head and foot.
+var pages = template.Must(template.New("pages").Parse(`
+{{define "head"}}<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 24rem; margin: 4rem auto; padding: 0 1rem; }
+a.button, button { display: block; width: 100%; box-sizing: border-box; margin: .5rem 0; padding: .75rem 1rem; border: 1px solid #ccc; border-radius: .5rem; background: none; color: inherit; font: inherit; text-align: center; text-decoration: none; cursor: pointer; }
+a.button:hover, button:hover { background: #f4f4f4; }
+input:not([type]) { display: block; width: 100%; box-sizing: border-box; padding: .75rem 1rem; border: 1px solid #ccc; border-radius: .5rem; font: inherit; font-size: 1.5rem; letter-spacing: .2em; text-align: center; text-transform: uppercase; }
+label { display: block; margin: 1rem 0; }
+.note { color: #666; font-size: .875rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>{{.}}</h1>
+{{end}}
+
+{{define "foot"}}</body>
+</html>
+{{end}}
+
+{{define "chooser"}}{{template "head" "Sign in to JustLog"}}
+{{range .}}<a class="button" href="{{.URL}}">Continue with {{.Title}}</a>
+{{end}}{{template "foot"}}{{end}}
+
+{{define "device"}}{{template "head" "Connect a device"}}
+<p>Enter the code shown on your device.</p>
+{{with .Error}}<p class="error">{{.}}</p>{{end}}
+<form method="get" action="/oauth/device">
+<input name="user_code" value="{{.UserCode}}" placeholder="XXXX-XXXX" autocomplete="off" autocapitalize="characters" spellcheck="false" autofocus required>
+<button type="submit">Continue</button>
+</form>
+{{template "foot"}}{{end}}
+
+{{define "consent"}}{{template "head" "Allow access?"}}
+<p><strong>{{or .ClientName "An unnamed app"}}</strong> wants to access your JustLog account.</p>
+{{with .UserCode}}<p>A device is asking to connect. Confirm this code matches your device: <strong>{{.}}</strong></p>
+<p class="note">If you didn't start this yourself, for example because someone sent you the code, deny it.</p>
+{{end}}<p class="note">Apps register themselves, so JustLog can't vouch for this name. Only continue if you just connected it.</p>
+<p>It will be able to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+{{with .RedirectHost}}<p>You'll be sent back to <strong>{{.}}</strong>.</p>{{end}}
+<form method="post" action="/oauth/consent">
+<input type="hidden" name="session_id" value="{{.SessionID}}">
+<input type="hidden" name="consent_token" value="{{.Token}}">
+{{if .CanRemember}}<label><input type="checkbox" name="remember" value="1"> Don't ask again for this app</label>
+{{end}}<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+{{template "foot"}}{{end}}
+
+{{define "message"}}{{template "head" .Title}}
+<p>{{.Message}}</p>
+{{template "foot"}}{{end}}
+`))
+
+func renderPage(w http.ResponseWriter, status int, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// Keep the consent buttons from being framed and clickjacked
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+	if err := pages.ExecuteTemplate(w, name, data); err != nil {
+		slog.Error("render page", "page", name, "err", err)
+	}
+}
+
+func renderMessage(w http.ResponseWriter, status int, title, message string) {
+	renderPage(w, status, "message", map[string]string{"Title": title, "Message": message})
+}
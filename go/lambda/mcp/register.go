@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+	mcpauth "github.com/BrianLeishman/justlog.io/go/lambda/mcp/auth"
+	"github.com/google/uuid"
+)
+
+// Limits on what a client can register. Registration is unauthenticated,
+// so everything stored is bounded.
+const (
+	maxRegistrationBody = 16 << 10
+	maxRedirectURIs     = 10
+	maxContacts         = 5
+	maxURILen           = 2000
+	maxTextLen          = 200
+)
+
+var supportedGrantTypes = []string{"authorization_code", "refresh_token", grantTypeDeviceCode}
+
+// clientMetadata is an RFC 7591 registration request.
+type clientMetadata struct {
+	RedirectURIs            []string        `json:"redirect_uris"`
+	TokenEndpointAuthMethod string          `json:"token_endpoint_auth_method"`
+	GrantTypes              []string        `json:"grant_types"`
+	ResponseTypes           []string        `json:"response_types"`
+	ClientName              string          `json:"client_name"`
+	ClientURI               string          `json:"client_uri"`
+	LogoURI                 string          `json:"logo_uri"`
+	Scope                   string          `json:"scope"`
+	Contacts                []string        `json:"contacts"`
+	TOSURI                  string          `json:"tos_uri"`
+	PolicyURI               string          `json:"policy_uri"`
+	JWKSURI                 string          `json:"jwks_uri"`
+	JWKS                    json.RawMessage `json:"jwks"`
+	SoftwareID              string          `json:"software_id"`
+	SoftwareVersion         string          `json:"software_version"`
+}
+
+// dedupe drops repeats, which DynamoDB string sets can't hold anyway.
+func dedupe(s []string) []string {
+	var out []string
+	for _, v := range s {
+		if !slices.Contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func validText(name, v string) error {
+	if utf8.RuneCountInString(v) > maxTextLen {
+		return fmt.Errorf("%s is longer than %d characters", name, maxTextLen)
+	}
+	if strings.ContainsFunc(v, unicode.IsControl) {
+		return fmt.Errorf("%s contains control characters", name)
+	}
+	return nil
+}
+
+func validWebURI(name, v string) error {
+	if v == "" {
+		return nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || u.Scheme != "https" || u.Host == "" || len(v) > maxURILen {
+		return fmt.Errorf("%s must be an https URL", name)
+	}
+	return nil
+}
+
+// toClient checks registration metadata against what this server supports,
+// fills in RFC 7591 defaults, and returns the client to store. Errors are
+// RFC 7591 error codes with a description.
+func (m clientMetadata) toClient() (dynamo.OAuthClient, string, error) {
+	invalid := func(format string, args ...any) (dynamo.OAuthClient, string, error) {
+		return dynamo.OAuthClient{}, "invalid_client_metadata", fmt.Errorf(format, args...)
+	}
+
+	if m.TokenEndpointAuthMethod == "" {
+		m.TokenEndpointAuthMethod = "none"
+	}
+	if m.TokenEndpointAuthMethod != "none" {
+		return invalid("token_endpoint_auth_method must be none; only public clients are supported")
+	}
+	if m.JWKSURI != "" || len(m.JWKS) > 0 {
+		return invalid("jwks and jwks_uri aren't supported for public clients")
+	}
+
+	if len(m.GrantTypes) == 0 {
+		m.GrantTypes = []string{"authorization_code", "refresh_token"}
+	}
+	m.GrantTypes = dedupe(m.GrantTypes)
+	for _, g := range m.GrantTypes {
+		if !slices.Contains(supportedGrantTypes, g) {
+			return invalid("unsupported grant_type %q", g)
+		}
+	}
+	usesCode := slices.Contains(m.GrantTypes, "authorization_code")
+	if len(m.ResponseTypes) == 0 && usesCode {
+		m.ResponseTypes = []string{"code"}
+	}
+	m.ResponseTypes = dedupe(m.ResponseTypes)
+	for _, t := range m.ResponseTypes {
+		if t != "code" {
+			return invalid("unsupported response_type %q", t)
+		}
+	}
+	if slices.Contains(m.ResponseTypes, "code") != usesCode {
+		return invalid("response_type code and grant_type authorization_code go together")
+	}
+
+	// Device-only clients (CLIs) have nowhere to redirect to
+	m.RedirectURIs = dedupe(m.RedirectURIs)
+	if len(m.RedirectURIs) == 0 && usesCode {
+		return dynamo.OAuthClient{}, "invalid_redirect_uri", fmt.Errorf("redirect_uris is required")
+	}
+	if len(m.RedirectURIs) > maxRedirectURIs {
+		return dynamo.OAuthClient{}, "invalid_redirect_uri", fmt.Errorf("at most %d redirect_uris are allowed", maxRedirectURIs)
+	}
+	for _, u := range m.RedirectURIs {
+		if len(u) > maxURILen {
+			return dynamo.OAuthClient{}, "invalid_redirect_uri", fmt.Errorf("redirect_uri is longer than %d characters", maxURILen)
+		}
+		if err := validRedirectURI(u); err != nil {
+			return dynamo.OAuthClient{}, "invalid_redirect_uri", err
+		}
+	}
+
+	m.ClientName = strings.TrimSpace(m.ClientName)
+	for name, v := range map[string]string{
+		"client_name":      m.ClientName,
+		"software_id":      m.SoftwareID,
+		"software_version": m.SoftwareVersion,
+	} {
+		if err := validText(name, v); err != nil {
+			return invalid("%v", err)
+		}
+	}
+	for name, v := range map[string]string{
+		"client_uri": m.ClientURI,
+		"logo_uri":   m.LogoURI,
+		"tos_uri":    m.TOSURI,
+		"policy_uri": m.PolicyURI,
+	} {
+		if err := validWebURI(name, v); err != nil {
+			return invalid("%v", err)
+		}
+	}
+
+	m.Contacts = dedupe(m.Contacts)
+	if len(m.Contacts) > maxContacts {
+		return invalid("at most %d contacts are allowed", maxContacts)
+	}
+	for _, c := range m.Contacts {
+		if c == "" {
+			return invalid("contacts can't be empty")
+		}
+		if err := validText("contact", c); err != nil {
+			return invalid("%v", err)
+		}
+	}
+
+	scopes, err := mcpauth.ParseScopes(m.Scope)
+	if err != nil {
+		return invalid("%v", err)
+	}
+
+	return dynamo.OAuthClient{
+		ClientName:              m.ClientName,
+		RedirectURIs:            m.RedirectURIs,
+		GrantTypes:              m.GrantTypes,
+		ResponseTypes:           m.ResponseTypes,
+		TokenEndpointAuthMethod: m.TokenEndpointAuthMethod,
+		Scope:                   strings.Join(scopes, " "),
+		ClientURI:               m.ClientURI,
+		LogoURI:                 m.LogoURI,
+		TOSURI:                  m.TOSURI,
+		PolicyURI:               m.PolicyURI,
+		Contacts:                m.Contacts,
+		SoftwareID:              m.SoftwareID,
+		SoftwareVersion:         m.SoftwareVersion,
+	}, "", nil
+}
+
+// clientScopes resolves the scopes an authorization asks for. A client's
+// registered scope is both the default and the most it can ask for;
+// clients registered without one default to full access.
+func clientScopes(c *dynamo.OAuthClient, requested string) ([]string, error) {
+	scopes, err := mcpauth.ParseScopes(requested)
+	if err != nil {
+		return nil, err
+	}
+	registered := strings.Fields(c.Scope)
+	if len(scopes) == 0 {
+		scopes = registered
+	}
+	if len(scopes) == 0 {
+		return []string{mcpauth.ScopeFull}, nil
+	}
+	if len(registered) > 0 {
+		if s := (mcpauth.User{Scopes: registered}).Missing(scopes...); s != "" {
+			return nil, fmt.Errorf("scope %q wasn't registered for this client", s)
+		}
+	}
+	return scopes, nil
+}
+
+// clientIP is the caller's address: API Gateway's source IP in Lambda, the
+// connection's address locally.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	hits, reset, err := dynamo.CountHit(r.Context(), "register#"+clientIP(r), time.Hour)
+	if err != nil {
+		// Don't turn clients away because the counter is unavailable
+		slog.ErrorContext(r.Context(), "count registration", "err", err)
+	} else if hits > cfg.RegistrationsPerHour {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+		http.Error(w, "too many registrations, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	var req clientMetadata
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRegistrationBody)).Decode(&req); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_client_metadata", "invalid JSON body")
+		return
+	}
+	c, code, err := req.toClient()
+	if err != nil {
+		tokenError(w, http.StatusBadRequest, code, err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	c.ClientID = uuid.New().String()
+	c.CreatedAt = now.Format(time.RFC3339)
+	c.ExpiresAt = now.Add(time.Duration(cfg.UnusedClientTTL))
+	token, err := c.NewRegistrationToken()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "registration token", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := dynamo.PutOAuthClient(r.Context(), c); err != nil {
+		slog.ErrorContext(r.Context(), "put oauth client", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	audit(r, dynamo.AuditGlobal, dynamo.AuditEvent{Type: dynamo.AuditClientRegistered, ClientID: c.ClientID, Detail: c.ClientName})
+
+	writeClient(w, http.StatusCreated, &c, token)
+}
+
+// writeClient echoes a client's registered metadata, per RFC 7591 section
+// 3.2.1 and RFC 7592 section 3. The registration access token is only
+// included when it's first issued.
+func writeClient(w http.ResponseWriter, status int, c *dynamo.OAuthClient, registrationToken string) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	var resp map[string]any
+	json.Unmarshal(b, &resp)
+	delete(resp, "created_at")
+	if t, err := time.Parse(time.RFC3339, c.CreatedAt); err == nil {
+		resp["client_id_issued_at"] = t.Unix()
+	}
+	if c.RegistrationHash != "" {
+		resp["registration_client_uri"] = cfg.BaseURL + "/oauth/register/" + c.ClientID
+	}
+	if registrationToken != "" {
+		resp["registration_access_token"] = registrationToken
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// managedClient authenticates an RFC 7592 request with the registration
+// access token. Unknown clients get the same 401 as wrong tokens, so the
+// endpoint doesn't reveal which client IDs exist.
+func managedClient(w http.ResponseWriter, r *http.Request) (*dynamo.OAuthClient, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	c, err := dynamo.GetOAuthClient(r.Context(), r.PathValue("client_id"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "get oauth client", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if c == nil || !c.RegistrationTokenValid(token) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	return c, true
+}
+
+// handleGetRegistration returns a client's current registration.
+func handleGetRegistration(w http.ResponseWriter, r *http.Request) {
+	c, ok := managedClient(w, r)
+	if !ok {
+		return
+	}
+	writeClient(w, http.StatusOK, c, "")
+}
+
+// handleDeleteRegistration deletes a client registration. Its grants stop
+// refreshing, and its access tokens run out within the hour.
+func handleDeleteRegistration(w http.ResponseWriter, r *http.Request) {
+	c, ok := managedClient(w, r)
+	if !ok {
+		return
+	}
+	if err := dynamo.DeleteOAuthClient(r.Context(), c.ClientID); err != nil {
+		slog.ErrorContext(r.Context(), "delete oauth client", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	audit(r, dynamo.AuditGlobal, dynamo.AuditEvent{Type: dynamo.AuditClientDeleted, ClientID: c.ClientID, Detail: c.ClientName})
+	w.WriteHeader(http.StatusNoContent)
+}
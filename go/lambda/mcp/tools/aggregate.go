@@ -0,0 +1,138 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+	mcpauth "github.com/BrianLeishman/justlog.io/go/lambda/mcp/auth"
+	"github.com/BrianLeishman/justlog.io/go/stats"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func init() {
+	Register(aggregateEntries)
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func aggregateEntries(s *Spec) {
+	s.Resource("entries")
+	s.Define("aggregate_entries",
+		mcp.WithDescription("Compute sums, averages, minimums, maximums and counts over logged entries, grouped by day, week, month, weekday, meal or tag. Use this instead of doing arithmetic on raw entries, e.g. \"average protein on weekdays in March\". Averages, minimums and maximums are taken over daily totals within each group. Weight values are in pounds."),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithOpenWorldHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithString("types", mcp.Description("Comma-separated entry types: food, exercise, weight (default: food)")),
+		mcp.WithString("fields", mcp.Description("Comma-separated fields. food: calories, protein, carbs, net_carbs, fat, fiber, caffeine, cholesterol, sodium, sugar. exercise: calories, duration. weight: value."), mcp.Required()),
+		mcp.WithString("metrics", mcp.Description("Comma-separated metrics: sum, avg, min, max, count (default: sum,avg)")),
+		mcp.WithString("group_by", mcp.Description("How to group results. Omit for a single overall row."), mcp.Enum(stats.AggregateGroups...)),
+		mcp.WithString("weekdays", mcp.Description("Only include these days of the week: comma-separated mon..sun, or \"weekdays\" / \"weekends\"")),
+		mcp.WithString("from", mcp.Description("Start date, ISO 8601 (e.g. 2026-02-05). Defaults to 30 days ago.")),
+		mcp.WithString("to", mcp.Description("End date, ISO 8601 (e.g. 2026-02-05). Defaults to today.")),
+		mcp.WithString("format", mcp.Description("Output format: table (default) or json"), mcp.Enum("table", "json")),
+	)
+	s.EntryTypes(func(req mcp.CallToolRequest) []string {
+		return splitList(req.GetString("types", "food"))
+	})
+
+	s.Handler(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		uid, err := mcpauth.UserID(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		q := stats.AggregateQuery{
+			Types:   splitList(req.GetString("types", "food")),
+			Fields:  splitList(req.GetString("fields", "")),
+			Metrics: splitList(req.GetString("metrics", "sum,avg")),
+			GroupBy: req.GetString("group_by", ""),
+		}
+		q.Weekdays, err = parseWeekdays(req.GetString("weekdays", ""))
+		if err != nil {
+			return nil, err
+		}
+		if err := q.Validate(); err != nil {
+			return nil, err
+		}
+
+		loc := userTimezone(ctx, uid)
+		_, to := todayRange(loc)
+		from := to.AddDate(0, 0, -30)
+		if v := req.GetString("from", ""); v != "" {
+			t, err := time.ParseInLocation("2006-01-02", v, loc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid from date: %w", err)
+			}
+			from = t.UTC()
+		}
+		if v := req.GetString("to", ""); v != "" {
+			t, err := time.ParseInLocation("2006-01-02", v, loc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid to date: %w", err)
+			}
+			to = t.AddDate(0, 0, 1).UTC()
+		}
+
+		var entries []dynamo.Entry
+		for _, t := range q.Types {
+			e, err := dynamo.GetEntries(ctx, uid, t, from, to)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e...)
+		}
+
+		rows := stats.Aggregate(entries, loc, q)
+		if len(rows) == 0 {
+			return mcp.NewToolResultText("No matching entries found for that date range."), nil
+		}
+
+		if req.GetString("format", "table") == "json" {
+			b, _ := json.MarshalIndent(rows, "", "  ")
+			return mcp.NewToolResultText(string(b)), nil
+		}
+		header := fmt.Sprintf("%s to %s (%s)\n\n", from.In(loc).Format("2006-01-02"), to.In(loc).AddDate(0, 0, -1).Format("2006-01-02"), loc.String())
+		return mcp.NewToolResultText(header + stats.FormatTable(rows, q)), nil
+	})
+}
+
+// splitList splits a comma-separated parameter into trimmed, lowercased
+// values.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func parseWeekdays(v string) ([]time.Weekday, error) {
+	var out []time.Weekday
+	for _, s := range splitList(v) {
+		switch s {
+		case "weekdays":
+			out = append(out, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday)
+		case "weekends":
+			out = append(out, time.Saturday, time.Sunday)
+		default:
+			if len(s) < 3 {
+				return nil, fmt.Errorf("invalid weekday %q", s)
+			}
+			d, ok := weekdayNames[s[:3]]
+			if !ok {
+				return nil, fmt.Errorf("invalid weekday %q", s)
+			}
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
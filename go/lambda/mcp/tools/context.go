@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/BrianLeishman/justlog.io/go/dynamo"
+	"github.com/BrianLeishman/justlog.io/go/stats"
 )
 
 func buildContext(ctx context.Context, uid string) string {
@@ -86,42 +87,49 @@ func buildContext(ctx context.Context, uid string) string {
 	exercise7, _ := dynamo.GetEntries(ctx, uid, "exercise", sevenAgo, todayEnd)
 	exercise30, _ := dynamo.GetEntries(ctx, uid, "exercise", thirtyAgo, todayEnd)
 
+	// Average over days that actually have entries logged, so skipped days
+	// and days before sign-up don't drag the numbers down
+	days7 := loggedDays(food7, loc)
+	days30 := loggedDays(food30, loc)
+	exerciseDays7 := loggedDays(exercise7, loc)
+	exerciseDays30 := loggedDays(exercise30, loc)
+
 	b.WriteString("\n## Averages\n")
-	writeCalAvg(&b, "Calories in (7-day avg)", food7, 7, func(e dynamo.Entry) float64 { return e.Calories })
-	writeCalAvg(&b, "Calories in (30-day avg)", food30, 30, func(e dynamo.Entry) float64 { return e.Calories })
-	writeCalAvg(&b, "Protein (7-day avg)", food7, 7, func(e dynamo.Entry) float64 { return e.Protein })
-	writeCalAvg(&b, "Protein (30-day avg)", food30, 30, func(e dynamo.Entry) float64 { return e.Protein })
-	writeCalAvg(&b, "Calories burned (7-day avg)", exercise7, 7, func(e dynamo.Entry) float64 { return e.Calories })
-	writeCalAvg(&b, "Calories burned (30-day avg)", exercise30, 30, func(e dynamo.Entry) float64 { return e.Calories })
-
-	// 30-day weight history
+	writeCalAvg(&b, "Calories in (7-day avg)", food7, days7, func(e dynamo.Entry) float64 { return e.Calories })
+	writeCalAvg(&b, "Calories in (30-day avg)", food30, days30, func(e dynamo.Entry) float64 { return e.Calories })
+	writeCalAvg(&b, "Protein (7-day avg)", food7, days7, func(e dynamo.Entry) float64 { return e.Protein })
+	writeCalAvg(&b, "Protein (30-day avg)", food30, days30, func(e dynamo.Entry) float64 { return e.Protein })
+	writeCalAvg(&b, "Calories burned (7-day avg)", exercise7, exerciseDays7, func(e dynamo.Entry) float64 { return e.Calories })
+	writeCalAvg(&b, "Calories burned (30-day avg)", exercise30, exerciseDays30, func(e dynamo.Entry) float64 { return e.Calories })
+
+	// 30-day weight history, smoothed
 	weight30, _ := dynamo.GetEntries(ctx, uid, "weight", thirtyAgo, todayEnd)
-	b.WriteString("\n## Weight History (30 days)\n")
+	b.WriteString("\n## Weight Trend (30 days)\n")
 	if len(weight30) == 0 {
 		b.WriteString("No weight recordings in the last 30 days.\n")
 	} else {
-		// Group by day, take latest per day
-		byDay := map[string]dynamo.Entry{}
-		for _, e := range weight30 {
-			day := e.CreatedAt[:10]
-			if existing, ok := byDay[day]; !ok || e.CreatedAt > existing.CreatedAt {
-				byDay[day] = e
-			}
-		}
-		// Sort days
-		days := sortedKeys(byDay)
-		for _, day := range days {
-			e := byDay[day]
-			b.WriteString(fmt.Sprintf("- %s: %.1f %s\n", day, e.Value, e.Unit))
+		goal, _ := stats.ParseWeight(profile["ideal_weight"])
+		trend := stats.AnalyzeTrend(weight30, loc, goal)
+		writeTrendSummary(&b, trend)
+		for _, p := range trend.Points {
+			b.WriteString(fmt.Sprintf("- %s: %.1f %s (trend %.1f)\n", p.Day, p.Weight, trend.Unit, p.Trend))
 		}
 	}
 
+	// Adaptive expenditure over the last 30 complete days
+	b.WriteString("\n## Expenditure (adaptive, 30 days)\n")
+	if est, err := stats.EstimateExpenditure(before(food30, todayStart), before(weight30, todayStart), loc, 30); err != nil {
+		b.WriteString(fmt.Sprintf("Unavailable: %v.\n", err))
+	} else {
+		writeExpenditure(&b, est)
+	}
+
 	b.WriteString("\n=== END CONTEXT ===\n\n")
 	return b.String()
 }
 
 func writeCalAvg(b *strings.Builder, label string, entries []dynamo.Entry, days int, extract func(dynamo.Entry) float64) {
-	if len(entries) == 0 {
+	if len(entries) == 0 || days == 0 {
 		b.WriteString(fmt.Sprintf("- %s: no data\n", label))
 		return
 	}
@@ -129,20 +137,25 @@ func writeCalAvg(b *strings.Builder, label string, entries []dynamo.Entry, days
 	for _, e := range entries {
 		total += extract(e)
 	}
-	b.WriteString(fmt.Sprintf("- %s: %.0f/day\n", label, total/float64(days)))
+	b.WriteString(fmt.Sprintf("- %s: %.0f/day over %d logged days\n", label, total/float64(days), days))
 }
 
-func sortedKeys(m map[string]dynamo.Entry) []string {
-	out := make([]string, 0, len(m))
-	for k := range m {
-		out = append(out, k)
+// loggedDays counts the distinct local days that have entries.
+func loggedDays(entries []dynamo.Entry, loc *time.Location) int {
+	days := map[string]bool{}
+	for _, e := range entries {
+		days[stats.LocalDay(e, loc)] = true
 	}
-	// Simple sort — dates in YYYY-MM-DD format sort lexically
-	for i := range out {
-		for j := i + 1; j < len(out); j++ {
-			if out[j] < out[i] {
-				out[i], out[j] = out[j], out[i]
-			}
+	return len(days)
+}
+
+// before returns the entries created before t.
+func before(entries []dynamo.Entry, t time.Time) []dynamo.Entry {
+	cutoff := t.UTC().Format(time.RFC3339)
+	var out []dynamo.Entry
+	for _, e := range entries {
+		if e.CreatedAt < cutoff {
+			out = append(out, e)
 		}
 	}
 	return out
@@ -17,6 +17,7 @@ func init() {
 }
 
 func logExercise(s *Spec) {
+	s.Resource("exercise")
 	s.Define("log_exercise",
 		mcp.WithDescription("Log an exercise entry. Use this when the user tells you about a workout or physical activity."),
 		mcp.WithReadOnlyHintAnnotation(false),
@@ -63,6 +64,7 @@ func logExercise(s *Spec) {
 }
 
 func getExercise(s *Spec) {
+	s.Resource("exercise")
 	s.Define("get_exercise",
 		mcp.WithDescription("Get exercise entries for a date range. Defaults to today."),
 		mcp.WithReadOnlyHintAnnotation(true),
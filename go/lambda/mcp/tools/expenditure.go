@@ -0,0 +1,74 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+	mcpauth "github.com/BrianLeishman/justlog.io/go/lambda/mcp/auth"
+	"github.com/BrianLeishman/justlog.io/go/stats"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func init() {
+	Register(getExpenditureEstimate)
+}
+
+func getExpenditureEstimate(s *Spec) {
+	s.Resource("entries")
+	s.Define("get_expenditure_estimate",
+		mcp.WithDescription("Estimate the user's real maintenance calories (TDEE) from their logged food intake and smoothed weight trend. Prefer this over formula-based estimates when giving calorie advice."),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithOpenWorldHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithNumber("days", mcp.Description("Size of the rolling window in days, 14 to 90 (default: 28)")),
+	)
+	s.EntryTypes(func(req mcp.CallToolRequest) []string {
+		return []string{"food", "weight"}
+	})
+
+	s.Handler(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		uid, err := mcpauth.UserID(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		days := int(req.GetFloat("days", 28))
+		if days < 14 || days > 90 {
+			return nil, fmt.Errorf("days must be between 14 and 90")
+		}
+
+		loc := userTimezone(ctx, uid)
+		todayStart, _ := todayRange(loc)
+		from := todayStart.AddDate(0, 0, -days)
+
+		food, err := dynamo.GetEntries(ctx, uid, "food", from, todayStart)
+		if err != nil {
+			return nil, err
+		}
+		weight, err := dynamo.GetEntries(ctx, uid, "weight", from, todayStart)
+		if err != nil {
+			return nil, err
+		}
+
+		est, err := stats.EstimateExpenditure(food, weight, loc, days)
+		if errors.Is(err, stats.ErrInsufficientData) {
+			return mcp.NewToolResultText(fmt.Sprintf("Can't estimate expenditure over the last %d days: %v.", days, err)), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var b strings.Builder
+		writeExpenditure(&b, est)
+		return mcp.NewToolResultText(b.String()), nil
+	})
+}
+
+func writeExpenditure(b *strings.Builder, est stats.Expenditure) {
+	b.WriteString(fmt.Sprintf("- Estimated TDEE: %.0f cal/day (%s confidence)\n", est.TDEE, est.Confidence))
+	b.WriteString(fmt.Sprintf("- Average intake: %.0f cal/day over %d of %d days logged\n", est.AvgIntake, est.LoggedDays, est.Days))
+	b.WriteString(fmt.Sprintf("- Weight trend: %.1f → %.1f lbs (%+.2f lbs/week, %d weigh-ins)\n", est.TrendStart, est.TrendEnd, est.DailyChange*7, est.WeighIns))
+}
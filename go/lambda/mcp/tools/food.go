@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/BrianLeishman/justlog.io/go/dynamo"
@@ -17,6 +18,7 @@ func init() {
 }
 
 func logFood(s *Spec) {
+	s.Resource("food")
 	s.Define("log_food",
 		mcp.WithDescription("Log a food entry with nutritional info. Use this when the user tells you what they ate."),
 		mcp.WithReadOnlyHintAnnotation(false),
@@ -34,6 +36,8 @@ func logFood(s *Spec) {
 		mcp.WithNumber("sodium", mcp.Description("Sodium in milligrams")),
 		mcp.WithNumber("sugar", mcp.Description("Sugar in grams")),
 		mcp.WithString("notes", mcp.Description("Optional notes")),
+		mcp.WithString("meal", mcp.Description("Which meal this was part of"), mcp.Enum(meals...)),
+		mcp.WithString("tags", mcp.Description("Optional comma-separated tags, e.g. 'restaurant, chipotle'")),
 		mcp.WithString("timestamp", mcp.Description("ISO 8601 timestamp with timezone offset. IMPORTANT: call get_current_time first to get the correct time and offset. Example: 2026-02-08T17:30:00-05:00. Double-check AM vs PM."), mcp.Required()),
 	)
 
@@ -64,9 +68,17 @@ func logFood(s *Spec) {
 			Sodium:      req.GetFloat("sodium", 0),
 			Sugar:       req.GetFloat("sugar", 0),
 			Notes:       req.GetString("notes", ""),
+			Meal:        req.GetString("meal", ""),
+			Tags:        parseTags(req.GetString("tags", "")),
 			CreatedAt:   ts.Format(time.RFC3339),
 		}
 
+		_, carbsGiven := req.GetArguments()["carbs"]
+		warnings, err := validateFood(entry, carbsGiven)
+		if err != nil {
+			return nil, err
+		}
+
 		if err := dynamo.PutEntry(ctx, entry); err != nil {
 			return nil, fmt.Errorf("save food entry: %w", err)
 		}
@@ -90,14 +102,16 @@ func logFood(s *Spec) {
 		}
 
 		return mcp.NewToolResultText(fmt.Sprintf(
-			"Logged food: %s (%.0f cal) at %s (%s)\n\nDaily totals: %.0f cal | %.0fg protein | %.0fg carbs | %.0fg net carbs | %.0fg fat | %.0fg fiber",
+			"Logged food: %s (%.0f cal) at %s (%s)\n\nDaily totals: %.0f cal | %.0fg protein | %.0fg carbs | %.0fg net carbs | %.0fg fat | %.0fg fiber%s",
 			entry.Description, entry.Calories, localTime, loc.String(),
 			totCal, totP, totC, totNC, totFat, totFiber,
+			formatWarnings(warnings),
 		)), nil
 	})
 }
 
 func getFood(s *Spec) {
+	s.Resource("food")
 	s.Define("get_food",
 		mcp.WithDescription("Get food entries for a date range. Defaults to today."),
 		mcp.WithReadOnlyHintAnnotation(true),
@@ -144,6 +158,21 @@ func getFood(s *Spec) {
 	})
 }
 
+// meals are the values accepted for a food entry's meal.
+var meals = []string{"breakfast", "lunch", "dinner", "snack"}
+
+// parseTags splits a comma-separated tag list, normalizing case and dropping
+// blanks and duplicates.
+func parseTags(v string) []string {
+	var out []string
+	for _, t := range splitList(v) {
+		if !slices.Contains(out, t) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
 func parseTimestamp(v string) (time.Time, error) {
 	if v == "" {
 		return time.Now().UTC(), nil
@@ -2,7 +2,9 @@ package tools
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/BrianLeishman/justlog.io/go/dynamo"
@@ -18,6 +20,7 @@ func init() {
 }
 
 func updateFood(s *Spec) {
+	s.Resource("food")
 	s.Define("update_food",
 		mcp.WithDescription("Update an existing food entry. Pass the entry's sk (sort key) and any fields to change."),
 		mcp.WithReadOnlyHintAnnotation(false),
@@ -37,6 +40,8 @@ func updateFood(s *Spec) {
 		mcp.WithNumber("sugar", mcp.Description("New sugar in grams")),
 		mcp.WithString("timestamp", mcp.Description("New ISO 8601 timestamp")),
 		mcp.WithString("notes", mcp.Description("New notes")),
+		mcp.WithString("meal", mcp.Description("New meal"), mcp.Enum(meals...)),
+		mcp.WithString("tags", mcp.Description("New comma-separated tags, replacing the existing ones")),
 	)
 
 	s.Handler(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -51,6 +56,12 @@ func updateFood(s *Spec) {
 		if v := req.GetString("description", ""); v != "" {
 			fields["description"] = v
 		}
+		if v := req.GetString("meal", ""); v != "" {
+			fields["meal"] = v
+		}
+		if tags := parseTags(req.GetString("tags", "")); len(tags) > 0 {
+			fields["tags"] = tags
+		}
 		setFloat(fields, req, "calories", "calories")
 		setFloat(fields, req, "protein", "protein")
 		setFloat(fields, req, "carbs", "carbs")
@@ -76,15 +87,39 @@ func updateFood(s *Spec) {
 			return mcp.NewToolResultText("No fields to update."), nil
 		}
 
-		if err := dynamo.UpdateEntry(ctx, uid, sk, fields); err != nil {
+		existing, err := dynamo.GetEntry(ctx, uid, sk)
+		if err != nil {
+			return nil, fmt.Errorf("get food entry: %w", err)
+		}
+		if existing == nil || existing.Type != "food" {
+			return nil, fmt.Errorf("food entry %s not found", sk)
+		}
+		updated, err := mergeEntry(*existing, fields)
+		if err != nil {
+			return nil, fmt.Errorf("merge food entry: %w", err)
+		}
+		// The stored carbs are merged in, so this only needs to know about
+		// a 0 passed now
+		_, carbsGiven := req.GetArguments()["carbs"]
+		warnings, err := validateFood(updated, carbsGiven)
+		if err != nil {
+			return nil, err
+		}
+
+		err = dynamo.UpdateEntry(ctx, uid, "food", sk, fields)
+		if errors.Is(err, dynamo.ErrEntryNotFound) || errors.Is(err, dynamo.ErrNotEntry) {
+			return nil, fmt.Errorf("food entry %s not found", sk)
+		}
+		if err != nil {
 			return nil, fmt.Errorf("update food entry: %w", err)
 		}
 
-		return mcp.NewToolResultText(fmt.Sprintf("Updated food entry %s", sk)), nil
+		return mcp.NewToolResultText(fmt.Sprintf("Updated food entry %s%s", sk, formatWarnings(warnings))), nil
 	})
 }
 
 func updateExercise(s *Spec) {
+	s.Resource("exercise")
 	s.Define("update_exercise",
 		mcp.WithDescription("Update an existing exercise entry. Pass the entry's sk (sort key) and any fields to change."),
 		mcp.WithReadOnlyHintAnnotation(false),
@@ -127,7 +162,11 @@ func updateExercise(s *Spec) {
 			return mcp.NewToolResultText("No fields to update."), nil
 		}
 
-		if err := dynamo.UpdateEntry(ctx, uid, sk, fields); err != nil {
+		err = dynamo.UpdateEntry(ctx, uid, "exercise", sk, fields)
+		if errors.Is(err, dynamo.ErrEntryNotFound) || errors.Is(err, dynamo.ErrNotEntry) {
+			return nil, fmt.Errorf("exercise entry %s not found", sk)
+		}
+		if err != nil {
 			return nil, fmt.Errorf("update exercise entry: %w", err)
 		}
 
@@ -136,6 +175,7 @@ func updateExercise(s *Spec) {
 }
 
 func updateWeight(s *Spec) {
+	s.Resource("weight")
 	s.Define("update_weight",
 		mcp.WithDescription("Update an existing weight entry. Pass the entry's sk (sort key) and any fields to change."),
 		mcp.WithReadOnlyHintAnnotation(false),
@@ -176,7 +216,11 @@ func updateWeight(s *Spec) {
 			return mcp.NewToolResultText("No fields to update."), nil
 		}
 
-		if err := dynamo.UpdateEntry(ctx, uid, sk, fields); err != nil {
+		err = dynamo.UpdateEntry(ctx, uid, "weight", sk, fields)
+		if errors.Is(err, dynamo.ErrEntryNotFound) || errors.Is(err, dynamo.ErrNotEntry) {
+			return nil, fmt.Errorf("weight entry %s not found", sk)
+		}
+		if err != nil {
 			return nil, fmt.Errorf("update weight entry: %w", err)
 		}
 
@@ -185,6 +229,7 @@ func updateWeight(s *Spec) {
 }
 
 func deleteEntry(s *Spec) {
+	s.Resource("entries")
 	s.Define("delete_entry",
 		mcp.WithDescription("Delete an entry by its sort key."),
 		mcp.WithReadOnlyHintAnnotation(false),
@@ -192,6 +237,12 @@ func deleteEntry(s *Spec) {
 		mcp.WithDestructiveHintAnnotation(true),
 		mcp.WithString("sk", mcp.Description("The sort key of the entry to delete"), mcp.Required()),
 	)
+	s.EntryTypes(func(req mcp.CallToolRequest) []string {
+		// Entry sort keys start with their type. Anything else in the
+		// user's partition needs a scope nobody can hold but full access.
+		t, _, _ := strings.Cut(req.GetString("sk", ""), "#")
+		return []string{t}
+	})
 
 	s.Handler(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		uid, err := mcpauth.UserID(ctx)
@@ -18,6 +18,7 @@ func init() {
 
 func getProfile(s *Spec) {
 	s.SkipProfileCheck()
+	s.Resource("profile")
 	s.Define("get_profile",
 		mcp.WithDescription("Get the user's profile. The profile contains required information about the user that all other tools need. If any fields are missing, you MUST ask the user to fill them in before doing anything else."),
 		mcp.WithReadOnlyHintAnnotation(true),
@@ -55,6 +56,7 @@ func updateProfile(s *Spec) {
 		mcp.WithOpenWorldHintAnnotation(false),
 		mcp.WithDestructiveHintAnnotation(false),
 	)
+	s.Resource("profile")
 	s.Define("update_profile", opts...)
 
 	s.Handler(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -15,6 +15,8 @@ type Spec struct {
 	tool           mcp.Tool
 	handler        server.ToolHandlerFunc
 	skipProfileReq bool
+	resource       string
+	entryTypes     func(req mcp.CallToolRequest) []string
 }
 
 func (s *Spec) Define(name string, opts ...mcp.ToolOption) {
@@ -30,6 +32,44 @@ func (s *Spec) SkipProfileCheck() {
 	s.skipProfileReq = true
 }
 
+// Resource names what the tool reads or writes, for scope checks: "profile",
+// an entry type, or "entries" for tools that work across types. Whether the
+// read or write scope is needed comes from the tool's annotations. Tools
+// without a resource only need a valid credential.
+func (s *Spec) Resource(resource string) {
+	s.resource = resource
+}
+
+// EntryTypes narrows an "entries" tool's scope check to the entry types a
+// call actually touches, so per-type scopes are enough. Returning no types
+// falls back to the entries scope.
+func (s *Spec) EntryTypes(fn func(req mcp.CallToolRequest) []string) {
+	s.entryTypes = fn
+}
+
+// requiredScopes returns the scopes a call to the tool needs.
+func (s *Spec) requiredScopes(req mcp.CallToolRequest) []string {
+	if s.resource == "" {
+		return nil
+	}
+	ann := s.tool.Annotations
+	write := ann.ReadOnlyHint == nil || !*ann.ReadOnlyHint || (ann.DestructiveHint != nil && *ann.DestructiveHint)
+
+	if s.resource == "entries" && s.entryTypes != nil {
+		var scopes []string
+		for _, t := range s.entryTypes(req) {
+			scopes = append(scopes, mcpauth.EntryScope(t, write))
+		}
+		if len(scopes) > 0 {
+			return scopes
+		}
+	}
+	if write {
+		return []string{s.resource + ":write"}
+	}
+	return []string{s.resource + ":read"}
+}
+
 var registry []Spec
 
 func Register(fn func(*Spec)) {
@@ -45,23 +85,42 @@ func All() []server.ServerTool {
 		if !s.skipProfileReq {
 			handler = withProfileCheck(handler)
 		}
+		handler = withScopes(&s, handler)
 		handler = withContext(handler)
 		out[i] = server.ServerTool{Tool: s.tool, Handler: handler}
 	}
 	return out
 }
 
+func withScopes(s *Spec, next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		u, err := mcpauth.FromContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if missing := u.Missing(s.requiredScopes(req)...); missing != "" {
+			return mcp.NewToolResultError(fmt.Sprintf("PERMISSION DENIED — the credential this assistant is connected with doesn't have the %s scope, so %s can't be used. Tell the user; they can reconnect with broader access if they want this.", missing, s.tool.Name)), nil
+		}
+		return next(ctx, req)
+	}
+}
+
 func withContext(next server.ToolHandlerFunc) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		uid, err := mcpauth.UserID(ctx)
+		u, err := mcpauth.FromContext(ctx)
 		if err != nil {
 			return next(ctx, req)
 		}
+		uid := u.Sub
 
 		result, err := next(ctx, req)
 		if err != nil || result == nil {
 			return result, err
 		}
+		// The summary covers the profile and every entry type
+		if !u.AllowsAll(mcpauth.ScopeEntriesRead, mcpauth.ScopeProfileRead) {
+			return result, nil
+		}
 
 		summary := buildContext(ctx, uid)
 
@@ -0,0 +1,65 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+	mcpauth "github.com/BrianLeishman/justlog.io/go/lambda/mcp/auth"
+	"github.com/BrianLeishman/justlog.io/go/stats"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func init() {
+	Register(getReport)
+}
+
+func getReport(s *Spec) {
+	s.Resource("entries")
+	s.Define("get_report",
+		mcp.WithDescription("Get a weekly or monthly report: per-day totals, averages over logged days, best and worst days, macro split, exercise totals, weight change, and a comparison with the previous period."),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithOpenWorldHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithString("period", mcp.Description("Report period: week (Monday to Sunday) or month"), mcp.Required(), mcp.Enum("week", "month")),
+		mcp.WithString("date", mcp.Description("Any date inside the period, ISO 8601 (e.g. 2026-02-05). Defaults to today.")),
+		mcp.WithString("format", mcp.Description("Output format: markdown (default) or json"), mcp.Enum("markdown", "json")),
+	)
+	s.EntryTypes(func(req mcp.CallToolRequest) []string {
+		return []string{"food", "exercise", "weight"}
+	})
+
+	s.Handler(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		uid, err := mcpauth.UserID(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		profile, err := dynamo.GetProfile(ctx, uid)
+		if err != nil {
+			return nil, fmt.Errorf("get profile: %w", err)
+		}
+		loc := profile.Timezone()
+
+		date := time.Now().In(loc)
+		if v := req.GetString("date", ""); v != "" {
+			date, err = time.ParseInLocation("2006-01-02", v, loc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid date: %w", err)
+			}
+		}
+
+		r, err := stats.LoadReport(ctx, uid, profile, req.GetString("period", ""), date)
+		if err != nil {
+			return nil, err
+		}
+
+		if req.GetString("format", "markdown") == "json" {
+			b, _ := json.MarshalIndent(r, "", "  ")
+			return mcp.NewToolResultText(string(b)), nil
+		}
+		return mcp.NewToolResultText(r.Markdown()), nil
+	})
+}
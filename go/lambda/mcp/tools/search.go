@@ -0,0 +1,71 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+	mcpauth "github.com/BrianLeishman/justlog.io/go/lambda/mcp/auth"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func init() {
+	Register(searchEntries)
+}
+
+func searchEntries(s *Spec) {
+	s.Resource("entries")
+	s.Define("search_entries",
+		mcp.WithDescription("Search the descriptions and notes of all the user's past entries, e.g. \"when did I last eat at Chipotle?\". Matching is case-insensitive and tolerates typos. Results include each entry's sk for follow-up edits."),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithOpenWorldHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithString("query", mcp.Description("Words to search for"), mcp.Required()),
+		mcp.WithString("type", mcp.Description("Only search this entry type"), mcp.Enum("food", "exercise", "weight")),
+		mcp.WithNumber("limit", mcp.Description("Maximum number of results, 1 to 100 (default: 20)")),
+	)
+	s.EntryTypes(func(req mcp.CallToolRequest) []string {
+		if t := req.GetString("type", ""); t != "" {
+			return []string{t}
+		}
+		return nil
+	})
+
+	s.Handler(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		uid, err := mcpauth.UserID(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		limit := int(req.GetFloat("limit", 20))
+		if limit < 1 || limit > 100 {
+			return nil, fmt.Errorf("limit must be between 1 and 100")
+		}
+
+		hits, err := dynamo.SearchEntries(ctx, uid, req.GetString("query", ""), req.GetString("type", ""), limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(hits) == 0 {
+			return mcp.NewToolResultText("No matching entries found."), nil
+		}
+
+		loc := userTimezone(ctx, uid)
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("%d matching entries (%s):\n", len(hits), loc.String()))
+		for _, h := range hits {
+			when := h.CreatedAt
+			if t, err := time.Parse(time.RFC3339, h.CreatedAt); err == nil {
+				when = t.In(loc).Format("Mon Jan 2 2006 3:04 PM")
+			}
+			b.WriteString(fmt.Sprintf("- %s [%s] %s", when, h.Type, h.Description))
+			if h.Notes != "" {
+				b.WriteString(fmt.Sprintf(" (notes: %s)", h.Notes))
+			}
+			b.WriteString(fmt.Sprintf(" — sk: %s\n", h.SK))
+		}
+		return mcp.NewToolResultText(b.String()), nil
+	})
+}
@@ -0,0 +1,123 @@
+package tools
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+)
+
+// Thresholds above which a single food entry is flagged as suspicious.
+const (
+	macroMismatchRatio = 0.25
+	maxEntryCalories   = 2500
+	maxSnackCalories   = 1000
+	maxEntryProtein    = 200
+	maxEntrySodium     = 5000
+	maxEntryCaffeine   = 400
+	maxEntryCholest    = 1500
+)
+
+// validateFood checks a food entry's numbers for consistency. Values that
+// can't be right (negative amounts, net carbs or sugar above total carbs)
+// are returned as an error and the entry shouldn't be saved. Values that are
+// merely unlikely come back as warnings for the assistant to confirm with
+// the user. Carbs are stored as 0 when unknown, so carbsGiven says whether
+// a 0 was actually given and the other carb values must fit under it.
+func validateFood(e dynamo.Entry, carbsGiven bool) (warnings []string, err error) {
+	var problems []string
+	for _, f := range []struct {
+		name  string
+		value float64
+	}{
+		{"calories", e.Calories}, {"protein", e.Protein}, {"carbs", e.Carbs},
+		{"net_carbs", e.NetCarbs}, {"fat", e.Fat}, {"fiber", e.Fiber},
+		{"caffeine", e.Caffeine}, {"cholesterol", e.Cholesterol},
+		{"sodium", e.Sodium}, {"sugar", e.Sugar},
+	} {
+		if f.value < 0 {
+			problems = append(problems, fmt.Sprintf("%s can't be negative (got %g)", f.name, f.value))
+		}
+	}
+	if carbsGiven || e.Carbs > 0 {
+		if e.NetCarbs > e.Carbs {
+			problems = append(problems, fmt.Sprintf("net_carbs (%gg) can't be more than total carbs (%gg)", e.NetCarbs, e.Carbs))
+		}
+		if e.Sugar > e.Carbs {
+			problems = append(problems, fmt.Sprintf("sugar (%gg) can't be more than total carbs (%gg)", e.Sugar, e.Carbs))
+		}
+		if e.Fiber > e.Carbs {
+			problems = append(problems, fmt.Sprintf("fiber (%gg) can't be more than total carbs (%gg)", e.Fiber, e.Carbs))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid nutrition values: %s", strings.Join(problems, "; "))
+	}
+
+	macroCal := e.Protein*4 + e.Carbs*4 + e.Fat*9
+	switch {
+	case e.Calories == 0 && macroCal >= 50:
+		warnings = append(warnings, fmt.Sprintf("calories are missing but the macros add up to about %.0f cal", macroCal))
+	case e.Calories >= 50 && macroCal > 0:
+		if diff := math.Abs(e.Calories-macroCal) / e.Calories; diff > macroMismatchRatio {
+			warnings = append(warnings, fmt.Sprintf("%.0f cal doesn't match the macros (%.0fp/%.0fc/%.0ff ≈ %.0f cal, %.0f%% off); alcohol or rounding can explain some of this", e.Calories, e.Protein, e.Carbs, e.Fat, macroCal, diff*100))
+		}
+	}
+
+	switch {
+	case e.Meal == "snack" && e.Calories > maxSnackCalories:
+		warnings = append(warnings, fmt.Sprintf("%.0f cal is a lot for a snack", e.Calories))
+	case e.Calories > maxEntryCalories:
+		warnings = append(warnings, fmt.Sprintf("%.0f cal is unusually high for a single entry", e.Calories))
+	}
+	if e.Protein > maxEntryProtein {
+		warnings = append(warnings, fmt.Sprintf("%.0fg protein is unusually high for a single entry", e.Protein))
+	}
+	if e.Sodium > maxEntrySodium {
+		warnings = append(warnings, fmt.Sprintf("%.0fmg sodium is unusually high; check it wasn't entered in grams", e.Sodium))
+	}
+	if e.Caffeine > maxEntryCaffeine {
+		warnings = append(warnings, fmt.Sprintf("%.0fmg caffeine in one entry is more than the 400mg daily guideline", e.Caffeine))
+	}
+	if e.Cholesterol > maxEntryCholest {
+		warnings = append(warnings, fmt.Sprintf("%.0fmg cholesterol is unusually high; check it wasn't entered in grams", e.Cholesterol))
+	}
+	return warnings, nil
+}
+
+// formatWarnings turns validation warnings into a note asking the assistant
+// to double-check with the user.
+func formatWarnings(warnings []string) string {
+	if len(warnings) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\nPLEASE CONFIRM WITH THE USER — these values look suspicious (the entry was saved; use update_food if anything is wrong):\n")
+	for _, w := range warnings {
+		b.WriteString("- " + w + "\n")
+	}
+	return b.String()
+}
+
+// mergeEntry applies a set of update fields (keyed by DynamoDB attribute
+// name, as passed to dynamo.UpdateEntry) to a copy of an entry.
+func mergeEntry(e dynamo.Entry, fields map[string]interface{}) (dynamo.Entry, error) {
+	item, err := attributevalue.MarshalMap(e)
+	if err != nil {
+		return e, err
+	}
+	for k, v := range fields {
+		av, err := attributevalue.Marshal(v)
+		if err != nil {
+			return e, err
+		}
+		item[k] = av
+	}
+	var out dynamo.Entry
+	if err := attributevalue.UnmarshalMap(item, &out); err != nil {
+		return e, err
+	}
+	return out, nil
+}
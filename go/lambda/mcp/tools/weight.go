@@ -4,19 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/BrianLeishman/justlog.io/go/dynamo"
 	mcpauth "github.com/BrianLeishman/justlog.io/go/lambda/mcp/auth"
+	"github.com/BrianLeishman/justlog.io/go/stats"
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
 func init() {
 	Register(logWeight)
 	Register(getWeight)
+	Register(getWeightTrend)
 }
 
 func logWeight(s *Spec) {
+	s.Resource("weight")
 	s.Define("log_weight",
 		mcp.WithDescription("Log a weight measurement. Use this when the user tells you their weight."),
 		mcp.WithReadOnlyHintAnnotation(false),
@@ -63,6 +67,7 @@ func logWeight(s *Spec) {
 }
 
 func getWeight(s *Spec) {
+	s.Resource("weight")
 	s.Define("get_weight",
 		mcp.WithDescription("Get weight entries for a date range. Defaults to today."),
 		mcp.WithReadOnlyHintAnnotation(true),
@@ -108,3 +113,71 @@ func getWeight(s *Spec) {
 		return mcp.NewToolResultText(string(b)), nil
 	})
 }
+
+func getWeightTrend(s *Spec) {
+	s.Resource("weight")
+	s.Define("get_weight_trend",
+		mcp.WithDescription("Get the user's smoothed weight trend (exponentially weighted moving average), weekly rate of change, and projected date to reach their ideal weight. Use this instead of raw readings when talking about progress, since daily weight is noisy."),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithOpenWorldHintAnnotation(false),
+		mcp.WithDestructiveHintAnnotation(false),
+		mcp.WithNumber("days", mcp.Description("How many days of history to include, 7 to 365 (default: 90)")),
+	)
+
+	s.Handler(func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		uid, err := mcpauth.UserID(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		days := int(req.GetFloat("days", 90))
+		if days < 7 || days > 365 {
+			return nil, fmt.Errorf("days must be between 7 and 365")
+		}
+
+		profile, err := dynamo.GetProfile(ctx, uid)
+		if err != nil {
+			return nil, fmt.Errorf("get profile: %w", err)
+		}
+		loc := profile.Timezone()
+		_, to := todayRange(loc)
+		from := to.AddDate(0, 0, -days)
+
+		entries, err := dynamo.GetEntries(ctx, uid, "weight", from, to)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			return mcp.NewToolResultText("No weight entries found for that date range."), nil
+		}
+
+		var goal float64
+		if u, _ := mcpauth.FromContext(ctx); u.Allows(mcpauth.ScopeProfileRead) {
+			goal, _ = stats.ParseWeight(profile["ideal_weight"])
+		}
+		trend := stats.AnalyzeTrend(entries, loc, goal)
+
+		var b strings.Builder
+		writeTrendSummary(&b, trend)
+		b.WriteString("\n")
+		for _, p := range trend.Points {
+			b.WriteString(fmt.Sprintf("- %s: %.1f %s (trend %.1f)\n", p.Day, p.Weight, trend.Unit, p.Trend))
+		}
+		return mcp.NewToolResultText(b.String()), nil
+	})
+}
+
+func writeTrendSummary(b *strings.Builder, t stats.Trend) {
+	b.WriteString(fmt.Sprintf("- Trend weight: %.1f %s (%+.1f %s/week)\n", t.Current, t.Unit, t.WeeklyRate, t.Unit))
+	if t.Goal == 0 {
+		return
+	}
+	switch t.GoalStatus {
+	case stats.GoalReached:
+		b.WriteString(fmt.Sprintf("- Goal %.1f %s: reached\n", t.Goal, t.Unit))
+	case stats.GoalProjected:
+		b.WriteString(fmt.Sprintf("- Goal %.1f %s: projected for %s at the current rate\n", t.Goal, t.Unit, t.GoalDate))
+	default:
+		b.WriteString(fmt.Sprintf("- Goal %.1f %s: no projection, trend is %s\n", t.Goal, t.Unit, t.GoalStatus))
+	}
+}
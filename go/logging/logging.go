@@ -0,0 +1,219 @@
+// Package logging sets up structured logging for the servers: JSON lines
+// tagged with a request ID and a hash of the user's ID, with tokens and
+// what users log redacted. Debug logging, which includes request content,
+// is turned on per user with the debug_users setting rather than globally.
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BrianLeishman/justlog.io/go/config"
+	"github.com/awslabs/aws-lambda-go-api-proxy/core"
+	"github.com/google/uuid"
+)
+
+const redacted = "[redacted]"
+
+// secretKeys are attributes that are never logged.
+var secretKeys = map[string]bool{
+	"token":                     true,
+	"access_token":              true,
+	"refresh_token":             true,
+	"id_token":                  true,
+	"api_key":                   true,
+	"authorization":             true,
+	"code":                      true,
+	"code_verifier":             true,
+	"device_code":               true,
+	"client_secret":             true,
+	"registration_access_token": true,
+}
+
+// contentKeys are attributes holding what users log: entries, profile
+// answers, search queries and MCP messages. They're only logged for users
+// with debug logging on.
+var contentKeys = map[string]bool{
+	"arguments": true,
+	"result":    true,
+	"body":      true,
+	"response":  true,
+	"query":     true,
+	"profile":   true,
+}
+
+// Setup makes the default slog logger, and so the log package, write
+// redacted JSON lines to w.
+func Setup(w io.Writer) {
+	slog.SetDefault(slog.New(&handler{
+		next: slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	}))
+}
+
+// request is what's known about the request a context belongs to. The user
+// is only known once the request has authenticated.
+type request struct {
+	id string
+
+	mu    sync.Mutex
+	user  string
+	debug bool
+}
+
+type requestKey struct{}
+
+func fromContext(ctx context.Context) *request {
+	if ctx == nil {
+		return nil
+	}
+	req, _ := ctx.Value(requestKey{}).(*request)
+	return req
+}
+
+func (req *request) get() (user string, debug bool) {
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	return req.user, req.debug
+}
+
+// SetUser records who a request is from, turning on debug logging for the
+// rest of it if they're one of the debug_users.
+func SetUser(ctx context.Context, uid string) {
+	req := fromContext(ctx)
+	if req == nil || uid == "" {
+		return
+	}
+	h := sha256.Sum256([]byte(uid))
+	debug := slices.Contains(config.Get().DebugUsers, uid)
+
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	req.user = hex.EncodeToString(h[:8])
+	req.debug = debug
+}
+
+func debugging(ctx context.Context) bool {
+	if req := fromContext(ctx); req != nil {
+		_, debug := req.get()
+		return debug
+	}
+	return false
+}
+
+// handler adds the request's ID and user to records and redacts their
+// attributes before passing them on.
+type handler struct {
+	next slog.Handler
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo || debugging(ctx)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	var debug bool
+	if req := fromContext(ctx); req != nil {
+		var user string
+		user, debug = req.get()
+		out.AddAttrs(slog.String("request_id", req.id))
+		if user != "" {
+			out.AddAttrs(slog.String("user", user))
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redact(a, debug))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	// Attributes bound to a logger outlive any one request, so content is
+	// always redacted from them
+	red := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		red[i] = redact(a, false)
+	}
+	return &handler{next: h.next.WithAttrs(red)}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{next: h.next.WithGroup(name)}
+}
+
+// redact replaces secrets, and content unless debug is set, with a
+// placeholder, looking inside groups.
+func redact(a slog.Attr, debug bool) slog.Attr {
+	key := strings.ToLower(a.Key)
+	if secretKeys[key] || (contentKeys[key] && !debug) {
+		return slog.String(a.Key, redacted)
+	}
+	v := a.Value.Resolve()
+	if v.Kind() != slog.KindGroup {
+		return slog.Attr{Key: a.Key, Value: v}
+	}
+	group := v.Group()
+	attrs := make([]any, len(group))
+	for i, g := range group {
+		attrs[i] = redact(g, debug)
+	}
+	return slog.Group(a.Key, attrs...)
+}
+
+// statusRecorder remembers the status a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Handler gives each request an ID, returned in the X-Request-Id header and
+// added to everything logged with its context, and logs its route, status,
+// latency and user once it's done. Behind API Gateway the ID is the
+// gateway's request ID.
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+		if gw, ok := core.GetAPIGatewayV2ContextFromContext(r.Context()); ok && gw.RequestID != "" {
+			req.id = gw.RequestID
+		} else {
+			req.id = uuid.New().String()
+		}
+		w.Header().Set("X-Request-Id", req.id)
+
+		ctx := context.WithValue(r.Context(), requestKey{}, req)
+		r = r.WithContext(ctx)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		// The mux fills in the pattern it matched, which unlike the path
+		// doesn't contain IDs
+		route := r.Pattern
+		if route == "" {
+			route = r.Method + " " + r.URL.Path
+		}
+		slog.LogAttrs(ctx, slog.LevelInfo, "request",
+			slog.String("route", route),
+			slog.Int("status", rec.status),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+		)
+	})
+}
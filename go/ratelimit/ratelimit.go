@@ -0,0 +1,164 @@
+// Package ratelimit throttles authenticated requests with token buckets, one
+// per credential and one per user, so a runaway client can't run up
+// DynamoDB reads. Buckets live in DynamoDB, shared by every Lambda
+// instance, or in memory for local runs.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BrianLeishman/justlog.io/go/config"
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+	mcpauth "github.com/BrianLeishman/justlog.io/go/lambda/mcp/auth"
+)
+
+const (
+	// putAttempts bounds how often a DynamoDB bucket update is retried
+	// when concurrent requests race for it.
+	putAttempts = 3
+	// memoryMaxEntries bounds the in-memory store; idle buckets are
+	// dropped once it's full.
+	memoryMaxEntries = 10000
+)
+
+// Check takes a token from u's credential bucket and then its user bucket.
+// It returns 0 if the request can go ahead, or how long to wait otherwise.
+// Failures to reach the store are logged and let the request through.
+func Check(ctx context.Context, u mcpauth.User) time.Duration {
+	cfg := config.Get()
+	if u.Credential != "" {
+		if wait := check(ctx, "credential#"+u.Credential, cfg.CredentialRequestsPerMinute); wait > 0 {
+			return wait
+		}
+	}
+	if u.Sub != "" {
+		return check(ctx, "user#"+u.Sub, cfg.UserRequestsPerMinute)
+	}
+	return 0
+}
+
+func check(ctx context.Context, key string, perMinute int) time.Duration {
+	if perMinute == 0 {
+		return 0
+	}
+	wait, err := store().take(ctx, key, perMinute)
+	if err != nil {
+		slog.ErrorContext(ctx, "rate limit", "bucket", strings.SplitN(key, "#", 2)[0], "err", err)
+		return 0
+	}
+	return wait
+}
+
+// take refills b for the time since it was last updated and takes a token
+// from it. It returns the updated bucket, or if it's empty, the bucket
+// unchanged and how long until it has a token again. Buckets hold a
+// minute's worth of requests and start full.
+func take(b dynamo.RateBucket, now time.Time, perMinute int) (dynamo.RateBucket, time.Duration) {
+	capacity := float64(perMinute)
+	perSecond := capacity / 60
+	tokens := capacity
+	if !b.UpdatedAt.IsZero() {
+		tokens = min(capacity, b.Tokens+now.Sub(b.UpdatedAt).Seconds()*perSecond)
+	}
+	if tokens < 1 {
+		return b, time.Duration((1 - tokens) / perSecond * float64(time.Second))
+	}
+	return dynamo.RateBucket{Tokens: tokens - 1, UpdatedAt: now}, 0
+}
+
+type bucketStore interface {
+	take(ctx context.Context, key string, perMinute int) (time.Duration, error)
+}
+
+var store = sync.OnceValue(func() bucketStore {
+	if config.Get().RateLimitStore == "memory" {
+		return &memoryStore{buckets: map[string]dynamo.RateBucket{}}
+	}
+	return dynamoStore{}
+})
+
+type dynamoStore struct{}
+
+func (dynamoStore) take(ctx context.Context, key string, perMinute int) (time.Duration, error) {
+	for range putAttempts {
+		prev, err := dynamo.GetRateBucket(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		b, wait := take(prev, time.Now(), perMinute)
+		if wait > 0 {
+			return wait, nil
+		}
+		err = dynamo.PutRateBucket(ctx, key, b, prev, time.Minute)
+		if !errors.Is(err, dynamo.ErrRateBucketChanged) {
+			return 0, err
+		}
+	}
+	// Losing every race means a burst of parallel requests is draining the
+	// bucket, which is what the limit is for, so refuse rather than fail
+	// open, and retry once a token has had time to come back
+	return time.Minute / time.Duration(perMinute), nil
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]dynamo.RateBucket
+}
+
+func (m *memoryStore) take(_ context.Context, key string, perMinute int) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if len(m.buckets) >= memoryMaxEntries {
+		// A bucket idle for a minute is full again, same as a new one
+		for k, b := range m.buckets {
+			if now.Sub(b.UpdatedAt) > time.Minute {
+				delete(m.buckets, k)
+			}
+		}
+	}
+	b, wait := take(m.buckets[key], now, perMinute)
+	if wait == 0 {
+		m.buckets[key] = b
+	}
+	return wait, nil
+}
+
+// RetryAfter formats a wait as a Retry-After header value in whole seconds.
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// Handler rejects requests whose bearer token is over its limit with 429
+// and a Retry-After header. Requests without a valid token go through
+// untouched for next to refuse.
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Lookups are cached, so next authenticating again is cheap
+		u, err := mcpauth.FromToken(r.Context(), token)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if wait := Check(r.Context(), u); wait > 0 {
+			w.Header().Set("Retry-After", RetryAfter(wait))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,344 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+)
+
+// AggregateFields lists the numeric fields that can be aggregated for each
+// entry type.
+var AggregateFields = map[string][]string{
+	"food":     {"calories", "protein", "carbs", "net_carbs", "fat", "fiber", "caffeine", "cholesterol", "sodium", "sugar"},
+	"exercise": {"calories", "duration"},
+	"weight":   {"value"},
+}
+
+// AggregateGroups lists the supported group-by values. An empty group-by
+// aggregates everything into a single row.
+var AggregateGroups = []string{"day", "week", "month", "weekday", "meal", "tag"}
+
+// AggregateMetrics lists the supported metrics.
+var AggregateMetrics = []string{"sum", "avg", "min", "max", "count"}
+
+// AggregateQuery describes what to compute over a set of entries.
+type AggregateQuery struct {
+	Types    []string
+	GroupBy  string
+	Metrics  []string
+	Fields   []string
+	Weekdays []time.Weekday
+}
+
+// AggregateRow is one group of an aggregation, with a value per column.
+type AggregateRow struct {
+	Group  string             `json:"group"`
+	Values map[string]float64 `json:"values"`
+}
+
+// Validate checks that every type, field, metric and group-by is supported.
+func (q AggregateQuery) Validate() error {
+	if len(q.Types) == 0 {
+		return fmt.Errorf("at least one entry type is required")
+	}
+	if len(q.Fields) == 0 {
+		return fmt.Errorf("at least one field is required")
+	}
+	for _, t := range q.Types {
+		fields, ok := AggregateFields[t]
+		if !ok {
+			return fmt.Errorf("invalid type %q: must be food, exercise or weight", t)
+		}
+		var applies bool
+		for _, f := range q.Fields {
+			applies = applies || slices.Contains(fields, f)
+		}
+		if !applies {
+			return fmt.Errorf("none of the requested fields apply to %s entries (valid: %s)", t, strings.Join(fields, ", "))
+		}
+	}
+	for _, f := range q.Fields {
+		var ok bool
+		for _, t := range q.Types {
+			ok = ok || slices.Contains(AggregateFields[t], f)
+		}
+		if !ok {
+			return fmt.Errorf("invalid field %q for %s", f, strings.Join(q.Types, "/"))
+		}
+	}
+	if q.GroupBy != "" && !slices.Contains(AggregateGroups, q.GroupBy) {
+		return fmt.Errorf("invalid group_by %q: must be one of %s", q.GroupBy, strings.Join(AggregateGroups, ", "))
+	}
+	if len(q.Metrics) == 0 {
+		return fmt.Errorf("at least one metric is required")
+	}
+	for _, m := range q.Metrics {
+		if !slices.Contains(AggregateMetrics, m) {
+			return fmt.Errorf("invalid metric %q: must be one of %s", m, strings.Join(AggregateMetrics, ", "))
+		}
+	}
+	return nil
+}
+
+// Columns returns the column names of the aggregation, in order. When more
+// than one type is queried, fields are prefixed with their type.
+func (q AggregateQuery) Columns() []string {
+	var cols []string
+	for _, t := range q.Types {
+		for _, f := range q.Fields {
+			if !slices.Contains(AggregateFields[t], f) {
+				continue
+			}
+			name := f
+			if len(q.Types) > 1 {
+				name = t + "." + f
+			}
+			for _, m := range q.Metrics {
+				cols = append(cols, m+"("+name+")")
+			}
+		}
+	}
+	return cols
+}
+
+// Aggregate groups entries and computes the query's metrics in loc. Entries
+// are first rolled up into daily totals per group (weight readings are
+// averaged instead of summed, and converted to pounds), and sum, avg, min
+// and max are taken over those daily totals. Count is the number of entries.
+func Aggregate(entries []dynamo.Entry, loc *time.Location, q AggregateQuery) []AggregateRow {
+	type unitKey struct{ group, day string }
+	type bucket struct {
+		sum   float64
+		count int
+	}
+	// column base name -> group/day -> bucket
+	units := map[string]map[unitKey]*bucket{}
+	averaged := map[string]bool{}
+	counts := map[string]map[string]int{}
+	groups := map[string]bool{}
+
+	for _, e := range entries {
+		if !slices.Contains(q.Types, e.Type) {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, e.CreatedAt)
+		if err != nil {
+			continue
+		}
+		t = t.In(loc)
+		if len(q.Weekdays) > 0 && !slices.Contains(q.Weekdays, t.Weekday()) {
+			continue
+		}
+		day := t.Format("2006-01-02")
+
+		for _, g := range groupKeys(e, t, q.GroupBy) {
+			groups[g] = true
+			for _, f := range q.Fields {
+				if !slices.Contains(AggregateFields[e.Type], f) {
+					continue
+				}
+				name := f
+				if len(q.Types) > 1 {
+					name = e.Type + "." + f
+				}
+				if units[name] == nil {
+					units[name] = map[unitKey]*bucket{}
+					counts[name] = map[string]int{}
+					averaged[name] = f == "value"
+				}
+				k := unitKey{g, day}
+				if units[name][k] == nil {
+					units[name][k] = &bucket{}
+				}
+				units[name][k].sum += fieldValue(e, f)
+				units[name][k].count++
+				counts[name][g]++
+			}
+		}
+	}
+
+	names := make([]string, 0, len(groups))
+	for g := range groups {
+		names = append(names, g)
+	}
+	sortGroups(names, q.GroupBy)
+
+	rows := make([]AggregateRow, 0, len(names))
+	for _, g := range names {
+		row := AggregateRow{Group: g, Values: map[string]float64{}}
+		for name, byUnit := range units {
+			var daily []float64
+			for k, b := range byUnit {
+				if k.group != g {
+					continue
+				}
+				v := b.sum
+				if averaged[name] {
+					v /= float64(b.count)
+				}
+				daily = append(daily, v)
+			}
+			if len(daily) == 0 {
+				continue
+			}
+			for _, m := range q.Metrics {
+				row.Values[m+"("+name+")"] = metric(m, daily, counts[name][g])
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// FormatTable renders aggregation rows as a compact markdown table.
+func FormatTable(rows []AggregateRow, q AggregateQuery) string {
+	cols := q.Columns()
+	groupCol := q.GroupBy
+	if groupCol == "" {
+		groupCol = "all"
+	}
+
+	var b strings.Builder
+	b.WriteString("| " + groupCol + " | " + strings.Join(cols, " | ") + " |\n")
+	b.WriteString("|---" + strings.Repeat("|---", len(cols)) + "|\n")
+	for _, r := range rows {
+		b.WriteString("| " + r.Group)
+		for _, c := range cols {
+			v, ok := r.Values[c]
+			switch {
+			case !ok:
+				b.WriteString(" | -")
+			case v == math.Trunc(v):
+				b.WriteString(fmt.Sprintf(" | %.0f", v))
+			default:
+				b.WriteString(fmt.Sprintf(" | %.1f", v))
+			}
+		}
+		b.WriteString(" |\n")
+	}
+	return b.String()
+}
+
+func groupKeys(e dynamo.Entry, t time.Time, groupBy string) []string {
+	switch groupBy {
+	case "day":
+		return []string{t.Format("2006-01-02")}
+	case "week":
+		monday := t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+		return []string{"week of " + monday.Format("2006-01-02")}
+	case "month":
+		return []string{t.Format("2006-01")}
+	case "weekday":
+		return []string{t.Weekday().String()}
+	case "meal":
+		if e.Meal != "" {
+			return []string{strings.ToLower(e.Meal)}
+		}
+		return []string{mealAt(t)}
+	case "tag":
+		if len(e.Tags) == 0 {
+			return []string{"(untagged)"}
+		}
+		return e.Tags
+	default:
+		return []string{"all"}
+	}
+}
+
+// mealAt guesses the meal an untagged food entry belongs to from the local
+// time it was eaten.
+func mealAt(t time.Time) string {
+	switch h := t.Hour(); {
+	case h >= 4 && h < 11:
+		return "breakfast"
+	case h >= 11 && h < 15:
+		return "lunch"
+	case h >= 17 && h < 22:
+		return "dinner"
+	default:
+		return "snack"
+	}
+}
+
+func sortGroups(groups []string, groupBy string) {
+	order := map[string]int{}
+	switch groupBy {
+	case "weekday":
+		for d := time.Monday; d <= time.Saturday; d++ {
+			order[d.String()] = int(d)
+		}
+		order[time.Sunday.String()] = 7
+	case "meal":
+		for i, m := range []string{"breakfast", "lunch", "dinner", "snack"} {
+			order[m] = i + 1
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		oi, oj := order[groups[i]], order[groups[j]]
+		if oi != oj {
+			if oi == 0 || oj == 0 {
+				return oj == 0
+			}
+			return oi < oj
+		}
+		return groups[i] < groups[j]
+	})
+}
+
+func metric(m string, daily []float64, count int) float64 {
+	switch m {
+	case "count":
+		return float64(count)
+	case "min", "max":
+		out := daily[0]
+		for _, v := range daily[1:] {
+			if (m == "min" && v < out) || (m == "max" && v > out) {
+				out = v
+			}
+		}
+		return out
+	}
+	var sum float64
+	for _, v := range daily {
+		sum += v
+	}
+	if m == "avg" {
+		return sum / float64(len(daily))
+	}
+	return sum
+}
+
+func fieldValue(e dynamo.Entry, field string) float64 {
+	switch field {
+	case "calories":
+		return e.Calories
+	case "protein":
+		return e.Protein
+	case "carbs":
+		return e.Carbs
+	case "net_carbs":
+		return e.NetCarbs
+	case "fat":
+		return e.Fat
+	case "fiber":
+		return e.Fiber
+	case "caffeine":
+		return e.Caffeine
+	case "cholesterol":
+		return e.Cholesterol
+	case "sodium":
+		return e.Sodium
+	case "sugar":
+		return e.Sugar
+	case "duration":
+		return e.Duration
+	case "value":
+		return Pounds(e)
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package stats
+
+import (
+	"errors"
+	"time"
+
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+)
+
+// kcalPerLb is the usual approximation of the energy stored in a pound of
+// body weight.
+const kcalPerLb = 3500
+
+// ErrInsufficientData is returned when there aren't enough food or weight
+// entries to back-calculate expenditure.
+var ErrInsufficientData = errors.New("not enough data: need at least 7 days of food logs and 2 weigh-ins a week or more apart")
+
+// Expenditure is an adaptive estimate of maintenance calories (TDEE), derived
+// from what the user ate and how their weight trend moved over a window.
+type Expenditure struct {
+	Days        int     `json:"days"`
+	LoggedDays  int     `json:"logged_days"`
+	WeighIns    int     `json:"weigh_ins"`
+	AvgIntake   float64 `json:"avg_intake"`
+	TrendStart  float64 `json:"trend_start"`
+	TrendEnd    float64 `json:"trend_end"`
+	DailyChange float64 `json:"daily_change"`
+	TDEE        float64 `json:"tdee"`
+	Confidence  string  `json:"confidence"`
+}
+
+// EstimateExpenditure back-calculates maintenance calories over a window of
+// days. Intake is averaged over days that have food logged, and the energy
+// balance comes from the change in the smoothed weight trend. The caller
+// should leave out the current day, since a partially logged day drags the
+// average down.
+func EstimateExpenditure(food, weight []dynamo.Entry, loc *time.Location, days int) (Expenditure, error) {
+	intake := map[string]float64{}
+	for _, e := range food {
+		intake[LocalDay(e, loc)] += e.Calories
+	}
+	var total float64
+	var logged int
+	for _, cal := range intake {
+		if cal > 0 {
+			total += cal
+			logged++
+		}
+	}
+
+	trend := WeightTrend(weight, loc)
+	if logged < 7 || len(trend) < 2 {
+		return Expenditure{}, ErrInsufficientData
+	}
+
+	first, last := trend[0], trend[len(trend)-1]
+	start, _ := time.Parse("2006-01-02", first.Day)
+	end, _ := time.Parse("2006-01-02", last.Day)
+	span := end.Sub(start).Hours() / 24
+	if span < 7 {
+		return Expenditure{}, ErrInsufficientData
+	}
+
+	est := Expenditure{
+		Days:       days,
+		LoggedDays: logged,
+		WeighIns:   len(trend),
+		AvgIntake:  total / float64(logged),
+		TrendStart: first.Trend,
+		TrendEnd:   last.Trend,
+	}
+	est.DailyChange = (last.Trend - first.Trend) / span
+	est.TDEE = est.AvgIntake - est.DailyChange*kcalPerLb
+
+	switch {
+	case logged >= 21 && float64(logged) >= 0.8*float64(days) && len(trend) >= 12:
+		est.Confidence = "high"
+	case logged >= 14 && len(trend) >= 6:
+		est.Confidence = "medium"
+	default:
+		est.Confidence = "low"
+	}
+	return est, nil
+}
@@ -0,0 +1,333 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+)
+
+// Period is a reporting window of whole local days. To is exclusive.
+type Period struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+// PeriodFor returns the week (Monday through Sunday) or calendar month that
+// contains date, along with the period before it. date should already be in
+// the user's timezone.
+func PeriodFor(name string, date time.Time) (cur, prev Period, err error) {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	switch name {
+	case "week":
+		offset := (int(day.Weekday()) + 6) % 7
+		start := day.AddDate(0, 0, -offset)
+		cur = Period{Name: name, From: start, To: start.AddDate(0, 0, 7)}
+		prev = Period{Name: name, From: start.AddDate(0, 0, -7), To: start}
+	case "month":
+		start := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location())
+		cur = Period{Name: name, From: start, To: start.AddDate(0, 1, 0)}
+		prev = Period{Name: name, From: start.AddDate(0, -1, 0), To: start}
+	default:
+		return Period{}, Period{}, fmt.Errorf("invalid period %q: must be \"week\" or \"month\"", name)
+	}
+	return cur, prev, nil
+}
+
+// DayTotals is everything logged on a single local day.
+type DayTotals struct {
+	Day      string  `json:"day"`
+	Calories float64 `json:"calories"`
+	Protein  float64 `json:"protein"`
+	Carbs    float64 `json:"carbs"`
+	NetCarbs float64 `json:"net_carbs"`
+	Fat      float64 `json:"fat"`
+	Fiber    float64 `json:"fiber"`
+	Burned   float64 `json:"burned"`
+	Net      float64 `json:"net"`
+}
+
+// MacroSplit is the share of calories from each macro, in percent.
+type MacroSplit struct {
+	Protein float64 `json:"protein"`
+	Carbs   float64 `json:"carbs"`
+	Fat     float64 `json:"fat"`
+}
+
+// ExerciseTotals sums the exercise logged over a period.
+type ExerciseTotals struct {
+	Sessions int     `json:"sessions"`
+	Minutes  float64 `json:"minutes"`
+	Calories float64 `json:"calories"`
+}
+
+// WeightChange is the first and last weight reading of a period.
+type WeightChange struct {
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+	Change float64 `json:"change"`
+	Unit   string  `json:"unit"`
+}
+
+// Comparison is the difference between a report and the period before it.
+type Comparison struct {
+	From         string        `json:"from"`
+	To           string        `json:"to"`
+	LoggedDays   int           `json:"logged_days"`
+	Averages     DayTotals     `json:"averages"`
+	Weight       *WeightChange `json:"weight,omitempty"`
+	CaloriesDiff float64       `json:"calories_diff"`
+	ProteinDiff  float64       `json:"protein_diff"`
+	BurnedDiff   float64       `json:"burned_diff"`
+}
+
+// Report summarizes a period of logging. Averages only count days that have
+// food logged, so skipped days and days before sign-up don't drag them down.
+type Report struct {
+	Period     string         `json:"period"`
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	Days       []DayTotals    `json:"days"`
+	LoggedDays int            `json:"logged_days"`
+	Averages   DayTotals      `json:"averages"`
+	Best       *DayTotals     `json:"best,omitempty"`
+	Worst      *DayTotals     `json:"worst,omitempty"`
+	Macros     MacroSplit     `json:"macros"`
+	Exercise   ExerciseTotals `json:"exercise"`
+	Weight     *WeightChange  `json:"weight,omitempty"`
+	Previous   *Comparison    `json:"previous,omitempty"`
+}
+
+// BuildReport totals the entries that fall inside p. Best and worst days are
+// ranked by net calories: lower is better unless preferHigher is set, as it
+// is for users trying to gain weight.
+func BuildReport(p Period, food, exercise, weight []dynamo.Entry, preferHigher bool) Report {
+	loc := p.From.Location()
+	r := Report{
+		Period: p.Name,
+		From:   p.From.Format("2006-01-02"),
+		To:     p.To.AddDate(0, 0, -1).Format("2006-01-02"),
+	}
+
+	byDay := map[string]*DayTotals{}
+	get := func(day string) *DayTotals {
+		if d, ok := byDay[day]; ok {
+			return d
+		}
+		d := &DayTotals{Day: day}
+		byDay[day] = d
+		return d
+	}
+
+	foodDays := map[string]bool{}
+	for _, e := range food {
+		day := LocalDay(e, loc)
+		if day < r.From || day > r.To {
+			continue
+		}
+		d := get(day)
+		d.Calories += e.Calories
+		d.Protein += e.Protein
+		d.Carbs += e.Carbs
+		d.NetCarbs += e.NetCarbs
+		d.Fat += e.Fat
+		d.Fiber += e.Fiber
+		foodDays[day] = true
+	}
+	for _, e := range exercise {
+		day := LocalDay(e, loc)
+		if day < r.From || day > r.To {
+			continue
+		}
+		get(day).Burned += e.Calories
+		r.Exercise.Sessions++
+		r.Exercise.Minutes += e.Duration
+		r.Exercise.Calories += e.Calories
+	}
+
+	for _, d := range byDay {
+		d.Net = d.Calories - d.Burned
+		r.Days = append(r.Days, *d)
+	}
+	sort.Slice(r.Days, func(i, j int) bool { return r.Days[i].Day < r.Days[j].Day })
+
+	better := func(a, b float64) bool {
+		if preferHigher {
+			return a > b
+		}
+		return a < b
+	}
+
+	var sum DayTotals
+	for _, d := range r.Days {
+		if !foodDays[d.Day] {
+			continue
+		}
+		r.LoggedDays++
+		sum.Calories += d.Calories
+		sum.Protein += d.Protein
+		sum.Carbs += d.Carbs
+		sum.NetCarbs += d.NetCarbs
+		sum.Fat += d.Fat
+		sum.Fiber += d.Fiber
+		sum.Burned += d.Burned
+
+		if r.Best == nil || better(d.Net, r.Best.Net) {
+			r.Best = &d
+		}
+		if r.Worst == nil || better(r.Worst.Net, d.Net) {
+			r.Worst = &d
+		}
+	}
+	if r.LoggedDays > 0 {
+		n := float64(r.LoggedDays)
+		r.Averages = DayTotals{
+			Calories: sum.Calories / n,
+			Protein:  sum.Protein / n,
+			Carbs:    sum.Carbs / n,
+			NetCarbs: sum.NetCarbs / n,
+			Fat:      sum.Fat / n,
+			Fiber:    sum.Fiber / n,
+			Burned:   sum.Burned / n,
+			Net:      (sum.Calories - sum.Burned) / n,
+		}
+	}
+	if r.LoggedDays < 2 {
+		r.Best, r.Worst = nil, nil
+	}
+
+	if macroCal := sum.Protein*4 + sum.Carbs*4 + sum.Fat*9; macroCal > 0 {
+		r.Macros = MacroSplit{
+			Protein: sum.Protein * 4 / macroCal * 100,
+			Carbs:   sum.Carbs * 4 / macroCal * 100,
+			Fat:     sum.Fat * 9 / macroCal * 100,
+		}
+	}
+
+	r.Weight = weightChange(weight, loc, r.From, r.To)
+	return r
+}
+
+func weightChange(entries []dynamo.Entry, loc *time.Location, from, to string) *WeightChange {
+	var in []dynamo.Entry
+	for _, e := range entries {
+		if day := LocalDay(e, loc); day >= from && day <= to {
+			in = append(in, e)
+		}
+	}
+	t := AnalyzeTrend(in, loc, 0)
+	if len(t.Points) == 0 {
+		return nil
+	}
+	first, last := t.Points[0], t.Points[len(t.Points)-1]
+	return &WeightChange{Start: first.Weight, End: last.Weight, Change: last.Weight - first.Weight, Unit: t.Unit}
+}
+
+// Compare records how r differs from the report for the previous period.
+func (r *Report) Compare(prev Report) {
+	r.Previous = &Comparison{
+		From:       prev.From,
+		To:         prev.To,
+		LoggedDays: prev.LoggedDays,
+		Averages:   prev.Averages,
+		Weight:     prev.Weight,
+	}
+	if r.LoggedDays > 0 && prev.LoggedDays > 0 {
+		r.Previous.CaloriesDiff = r.Averages.Calories - prev.Averages.Calories
+		r.Previous.ProteinDiff = r.Averages.Protein - prev.Averages.Protein
+		r.Previous.BurnedDiff = r.Averages.Burned - prev.Averages.Burned
+	}
+}
+
+// LoadReport fetches the entries for the period containing date and the one
+// before it, and builds a report comparing the two.
+func LoadReport(ctx context.Context, uid string, profile dynamo.Profile, period string, date time.Time) (Report, error) {
+	loc := profile.Timezone()
+	cur, prev, err := PeriodFor(period, date.In(loc))
+	if err != nil {
+		return Report{}, err
+	}
+
+	from, to := prev.From.UTC(), cur.To.UTC()
+	food, err := dynamo.GetEntries(ctx, uid, "food", from, to)
+	if err != nil {
+		return Report{}, err
+	}
+	exercise, err := dynamo.GetEntries(ctx, uid, "exercise", from, to)
+	if err != nil {
+		return Report{}, err
+	}
+	weight, err := dynamo.GetEntries(ctx, uid, "weight", from, to)
+	if err != nil {
+		return Report{}, err
+	}
+
+	goal := strings.ToLower(profile["goal"])
+	preferHigher := strings.Contains(goal, "gain") || strings.Contains(goal, "bulk")
+
+	r := BuildReport(cur, food, exercise, weight, preferHigher)
+	r.Compare(BuildReport(prev, food, exercise, weight, preferHigher))
+	return r, nil
+}
+
+// Markdown renders the report for reading in a chat.
+func (r Report) Markdown() string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("# %s report: %s to %s\n\n", strings.ToUpper(r.Period[:1])+r.Period[1:], r.From, r.To))
+
+	if r.LoggedDays == 0 {
+		b.WriteString("No food logged in this period.\n")
+	} else {
+		a := r.Averages
+		b.WriteString(fmt.Sprintf("## Averages (%d days logged)\n", r.LoggedDays))
+		b.WriteString(fmt.Sprintf("- Calories: %.0f/day (net %.0f)\n", a.Calories, a.Net))
+		b.WriteString(fmt.Sprintf("- Protein: %.0fg, carbs: %.0fg (net %.0fg), fat: %.0fg, fiber: %.0fg\n", a.Protein, a.Carbs, a.NetCarbs, a.Fat, a.Fiber))
+		b.WriteString(fmt.Sprintf("- Macro split: %.0f%% protein / %.0f%% carbs / %.0f%% fat\n", r.Macros.Protein, r.Macros.Carbs, r.Macros.Fat))
+		if r.Best != nil && r.Worst != nil {
+			b.WriteString(fmt.Sprintf("- Best day: %s (%.0f cal net)\n", r.Best.Day, r.Best.Net))
+			b.WriteString(fmt.Sprintf("- Worst day: %s (%.0f cal net)\n", r.Worst.Day, r.Worst.Net))
+		}
+	}
+
+	b.WriteString("\n## Exercise\n")
+	if r.Exercise.Sessions == 0 {
+		b.WriteString("No exercise logged.\n")
+	} else {
+		b.WriteString(fmt.Sprintf("- %d sessions, %.0f min, %.0f cal burned\n", r.Exercise.Sessions, r.Exercise.Minutes, r.Exercise.Calories))
+	}
+
+	b.WriteString("\n## Weight\n")
+	if r.Weight == nil {
+		b.WriteString("No weigh-ins.\n")
+	} else {
+		b.WriteString(fmt.Sprintf("- %.1f → %.1f %s (%+.1f)\n", r.Weight.Start, r.Weight.End, r.Weight.Unit, r.Weight.Change))
+	}
+
+	if p := r.Previous; p != nil {
+		b.WriteString(fmt.Sprintf("\n## Compared to %s to %s\n", p.From, p.To))
+		if p.LoggedDays == 0 || r.LoggedDays == 0 {
+			b.WriteString("- Not enough food logged in both periods to compare.\n")
+		} else {
+			b.WriteString(fmt.Sprintf("- Calories: %+.0f/day (was %.0f)\n", p.CaloriesDiff, p.Averages.Calories))
+			b.WriteString(fmt.Sprintf("- Protein: %+.0fg/day (was %.0fg)\n", p.ProteinDiff, p.Averages.Protein))
+			b.WriteString(fmt.Sprintf("- Burned: %+.0f/day (was %.0f)\n", p.BurnedDiff, p.Averages.Burned))
+		}
+		if p.Weight != nil {
+			b.WriteString(fmt.Sprintf("- Weight change last period: %+.1f %s\n", p.Weight.Change, p.Weight.Unit))
+		}
+	}
+
+	if len(r.Days) > 0 {
+		b.WriteString("\n## Daily totals\n")
+		b.WriteString("| Day | Calories | Protein | Carbs | Fat | Burned | Net |\n")
+		b.WriteString("|---|---|---|---|---|---|---|\n")
+		for _, d := range r.Days {
+			b.WriteString(fmt.Sprintf("| %s | %.0f | %.0fg | %.0fg | %.0fg | %.0f | %.0f |\n", d.Day, d.Calories, d.Protein, d.Carbs, d.Fat, d.Burned, d.Net))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,204 @@
+package stats
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BrianLeishman/justlog.io/go/dynamo"
+)
+
+const lbsPerKg = 2.20462
+
+// trendAlpha is the smoothing factor for the weight trend, the same 10% the
+// Hacker's Diet uses.
+const trendAlpha = 0.1
+
+// rateWindow is how many days of trend the weekly rate of change looks at.
+const rateWindow = 14
+
+// DailyWeight is the last weight reading of a local day alongside the
+// smoothed trend value at that day. Both are in pounds.
+type DailyWeight struct {
+	Day    string  `json:"day"`
+	Weight float64 `json:"weight"`
+	Trend  float64 `json:"trend"`
+}
+
+// Pounds returns a weight entry's value in pounds.
+func Pounds(e dynamo.Entry) float64 {
+	if strings.EqualFold(e.Unit, "kg") {
+		return e.Value * lbsPerKg
+	}
+	return e.Value
+}
+
+// LocalDay returns the YYYY-MM-DD day an entry falls on in loc.
+func LocalDay(e dynamo.Entry, loc *time.Location) string {
+	t, err := time.Parse(time.RFC3339, e.CreatedAt)
+	if err != nil {
+		if len(e.CreatedAt) >= 10 {
+			return e.CreatedAt[:10]
+		}
+		return e.CreatedAt
+	}
+	return t.In(loc).Format("2006-01-02")
+}
+
+// WeightTrend reduces weight entries to the latest reading per local day and
+// smooths them with an exponentially weighted moving average. Days without a
+// reading carry the trend forward, so a gap of n days weighs the next reading
+// as if it had been seen n times.
+func WeightTrend(entries []dynamo.Entry, loc *time.Location) []DailyWeight {
+	latest := map[string]dynamo.Entry{}
+	for _, e := range entries {
+		day := LocalDay(e, loc)
+		if existing, ok := latest[day]; !ok || e.CreatedAt > existing.CreatedAt {
+			latest[day] = e
+		}
+	}
+
+	days := make([]string, 0, len(latest))
+	for day := range latest {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	out := make([]DailyWeight, 0, len(days))
+	var prev time.Time
+	var trend float64
+	for i, day := range days {
+		w := Pounds(latest[day])
+		t, _ := time.Parse("2006-01-02", day)
+		if i == 0 {
+			trend = w
+		} else {
+			gap := math.Round(t.Sub(prev).Hours() / 24)
+			alpha := 1 - math.Pow(1-trendAlpha, gap)
+			trend += alpha * (w - trend)
+		}
+		prev = t
+		out = append(out, DailyWeight{Day: day, Weight: w, Trend: trend})
+	}
+	return out
+}
+
+// Trend summarizes a weight history: the smoothed trend, how fast it's
+// moving, and when it's projected to reach the goal weight. Values are in
+// Unit, which follows the most recent reading.
+type Trend struct {
+	Points     []DailyWeight `json:"points"`
+	Unit       string        `json:"unit"`
+	Current    float64       `json:"current"`
+	WeeklyRate float64       `json:"weekly_rate"`
+	Goal       float64       `json:"goal,omitempty"`
+	GoalStatus string        `json:"goal_status,omitempty"`
+	GoalDate   string        `json:"goal_date,omitempty"`
+}
+
+// Goal statuses reported in Trend.GoalStatus.
+const (
+	GoalProjected  = "projected"
+	GoalReached    = "reached"
+	GoalMovingAway = "moving away"
+	GoalFlat       = "flat"
+)
+
+// AnalyzeTrend smooths weight entries and projects the date the trend
+// crosses goalLbs. Pass a goal of 0 to skip the projection.
+func AnalyzeTrend(entries []dynamo.Entry, loc *time.Location, goalLbs float64) Trend {
+	t := Trend{Points: WeightTrend(entries, loc), Unit: "lbs"}
+	if len(t.Points) == 0 {
+		return t
+	}
+
+	var newest dynamo.Entry
+	for _, e := range entries {
+		if e.CreatedAt > newest.CreatedAt {
+			newest = e
+		}
+	}
+	if strings.EqualFold(newest.Unit, "kg") {
+		t.Unit = "kg"
+	}
+
+	last := t.Points[len(t.Points)-1]
+	t.Current = last.Trend
+	daily := trendSlope(t.Points)
+	t.WeeklyRate = daily * 7
+
+	if goalLbs > 0 {
+		t.Goal = goalLbs
+		diff := goalLbs - t.Current
+		switch {
+		case math.Abs(diff) < 0.5:
+			t.GoalStatus = GoalReached
+		case math.Abs(t.WeeklyRate) < 0.05:
+			t.GoalStatus = GoalFlat
+		case (diff > 0) != (daily > 0):
+			t.GoalStatus = GoalMovingAway
+		default:
+			t.GoalStatus = GoalProjected
+			day, _ := time.Parse("2006-01-02", last.Day)
+			days := int(math.Ceil(diff / daily))
+			t.GoalDate = day.AddDate(0, 0, days).Format("2006-01-02")
+		}
+	}
+
+	if t.Unit == "kg" {
+		for i := range t.Points {
+			t.Points[i].Weight /= lbsPerKg
+			t.Points[i].Trend /= lbsPerKg
+		}
+		t.Current /= lbsPerKg
+		t.WeeklyRate /= lbsPerKg
+		t.Goal /= lbsPerKg
+	}
+	return t
+}
+
+// trendSlope fits a least-squares line through the trend over the last
+// rateWindow days and returns its slope in pounds per day.
+func trendSlope(points []DailyWeight) float64 {
+	last, _ := time.Parse("2006-01-02", points[len(points)-1].Day)
+	var n, sumX, sumY, sumXY, sumXX float64
+	for _, p := range points {
+		day, _ := time.Parse("2006-01-02", p.Day)
+		x := day.Sub(last).Hours() / 24
+		if x < -rateWindow {
+			continue
+		}
+		n++
+		sumX += x
+		sumY += p.Trend
+		sumXY += x * p.Trend
+		sumXX += x * x
+	}
+	denom := n*sumXX - sumX*sumX
+	if n < 2 || denom == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denom
+}
+
+var weightPattern = regexp.MustCompile(`(?i)^\s*([\d.]+)\s*(lbs?|pounds?|kgs?|kilos?|kilograms?)?\b`)
+
+// ParseWeight reads a free-form weight like "180 lbs" or "82 kg" and returns
+// it in pounds. A bare number is taken as pounds.
+func ParseWeight(s string) (float64, bool) {
+	m := weightPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil || v <= 0 {
+		return 0, false
+	}
+	if strings.HasPrefix(strings.ToLower(m[2]), "k") {
+		v *= lbsPerKg
+	}
+	return v, true
+}
@@ -102,3 +102,32 @@ export async function getEntries(type: string, from?: string, to?: string): Prom
         return [];
     }
 }
+
+export interface WeightTrendPoint {
+    day: string;
+    weight: number;
+    trend: number;
+}
+
+export interface WeightTrend {
+    points: WeightTrendPoint[];
+    unit: string;
+    current: number;
+    weekly_rate: number;
+    goal?: number;
+    goal_status?: string;
+    goal_date?: string;
+}
+
+export async function getWeightTrend(days: number): Promise<WeightTrend | null> {
+    if (!getAccessToken()) {
+        return null;
+    }
+
+    try {
+        const { data } = await api.get<WeightTrend>('/api/weight/trend', { params: { days } });
+        return data;
+    } catch {
+        return null;
+    }
+}
@@ -1,7 +1,7 @@
 import { Chart, registerables } from 'chart.js';
 import { Tooltip } from 'bootstrap';
-import { getEntries } from './api';
-import type { Entry } from './api';
+import { getEntries, getWeightTrend } from './api';
+import type { Entry, WeightTrend } from './api';
 
 Chart.register(...registerables);
 
@@ -129,7 +129,7 @@ function renderTimeline(food: Entry[], exercise: Entry[], weight: Entry[]): stri
     return items + totalsCard;
 }
 
-function renderWeightChart(history: Entry[]): void {
+function renderWeightChart(history: Entry[], trend: WeightTrend | null): void {
     const canvas = document.getElementById('weight-chart') as HTMLCanvasElement | null;
     if (!canvas) {
         return;
@@ -145,9 +145,15 @@ function renderWeightChart(history: Entry[]): void {
         }
     }
 
+    const trendByDay = new Map<string, number>();
+    for (const p of trend?.points ?? []) {
+        trendByDay.set(p.day, p.trend);
+    }
+
     // Generate all 30 days as labels, with null for missing days
     const labels: string[] = [];
     const data: (number | null)[] = [];
+    const trendData: (number | null)[] = [];
     const now = new Date();
     for (let i = 29; i >= 0; i--) {
         const d = new Date(now.getFullYear(), now.getMonth(), now.getDate() - i);
@@ -155,10 +161,12 @@ function renderWeightChart(history: Entry[]): void {
         labels.push(d.toLocaleDateString(undefined, { month: 'short', day: 'numeric' }));
         const entry = byDay.get(key);
         data.push(entry ? entry.value : null);
+        trendData.push(trendByDay.get(key) ?? null);
     }
 
     const style = getComputedStyle(document.documentElement);
     const primary = style.getPropertyValue('--bs-primary').trim() || '#0d6efd';
+    const secondary = style.getPropertyValue('--bs-secondary').trim() || '#6c757d';
     const textColor = style.getPropertyValue('--bs-body-color').trim() || '#dee2e6';
     const gridColor = style.getPropertyValue('--bs-border-color').trim() || '#495057';
 
@@ -175,6 +183,13 @@ function renderWeightChart(history: Entry[]): void {
                 pointBackgroundColor: primary,
                 showLine: true,
                 spanGaps: true,
+            }, {
+                label: 'Trend',
+                data: trendData,
+                borderColor: secondary,
+                borderDash: [6, 4],
+                pointRadius: 0,
+                spanGaps: true,
             }],
         },
         options: {
@@ -201,6 +216,24 @@ function renderWeightChart(history: Entry[]): void {
     });
 }
 
+function renderTrendSummary(trend: WeightTrend | null): string {
+    if (!trend || trend.points.length === 0) {
+        return '';
+    }
+
+    const rate = `${trend.weekly_rate > 0 ? '+' : ''}${weightFmt.format(trend.weekly_rate)} ${trend.unit}/week`;
+    let goal = '';
+    if (trend.goal && trend.goal_status === 'projected' && trend.goal_date) {
+        const [y, m, d] = trend.goal_date.split('-').map(Number);
+        const date = new Date(y, m - 1, d).toLocaleDateString(undefined, { month: 'short', day: 'numeric', year: 'numeric' });
+        goal = ` · ${weightFmt.format(trend.goal)} ${trend.unit} by ${date}`;
+    } else if (trend.goal && trend.goal_status === 'reached') {
+        goal = ` · goal of ${weightFmt.format(trend.goal)} ${trend.unit} reached`;
+    }
+
+    return `<div class="text-body-secondary small mb-2">${weightFmt.format(trend.current)} ${trend.unit} trend · ${rate}${goal}</div>`;
+}
+
 function fmtDate(d: Date): string {
     return `${d.getFullYear()}-${String(d.getMonth() + 1).padStart(2, '0')}-${String(d.getDate()).padStart(2, '0')}`;
 }
@@ -223,7 +256,7 @@ export async function renderDashboard(container: HTMLElement): Promise<void> {
     const ago7 = new Date(now);
     ago7.setDate(ago7.getDate() - 7);
     const sevenDaysAgo = fmtDate(ago7);
-    const [food, exercise, weight, weightHistory, food7, food30, exercise7, exercise30] = await Promise.all([
+    const [food, exercise, weight, weightHistory, food7, food30, exercise7, exercise30, weightTrend] = await Promise.all([
         getEntries('food', today, today),
         getEntries('exercise', today, today),
         getEntries('weight', today, today),
@@ -232,6 +265,7 @@ export async function renderDashboard(container: HTMLElement): Promise<void> {
         getEntries('food', thirtyDaysAgo, yesterdayStr),
         getEntries('exercise', sevenDaysAgo, yesterdayStr),
         getEntries('exercise', thirtyDaysAgo, yesterdayStr),
+        getWeightTrend(30),
     ]);
 
     const localDate = (iso: string) => fmtDate(new Date(iso));
@@ -288,11 +322,12 @@ export async function renderDashboard(container: HTMLElement): Promise<void> {
             </div>
             <div class="col-12">
                 <h4>Weight Trend</h4>
+                ${renderTrendSummary(weightTrend)}
                 <div style="position:relative; height:300px"><canvas id="weight-chart"></canvas></div>
             </div>
         </div>`;
 
-    renderWeightChart(weightHistory);
+    renderWeightChart(weightHistory, weightTrend);
 
     // Init tooltips
     container.querySelectorAll('[data-bs-toggle="tooltip"]').forEach(el => new Tooltip(el));
@@ -6,6 +6,27 @@ interface APIKeyInfo {
     key_id: string;
     label: string;
     created_at: string;
+    scopes?: string[];
+    expires_at?: string;
+    last_used_at?: string;
+    rotated_to?: string;
+}
+
+// Presets offered when creating a key. An empty list means full access.
+const accessLevels: Record<string, string[]> = {
+    full: [],
+    read: ['entries:read', 'profile:read'],
+    log: ['entries:write', 'profile:read'],
+};
+
+interface Connection {
+    client_id: string;
+    client_name: string;
+    registered_at?: string;
+    connected_at: string;
+    last_used_at?: string;
+    scopes: string[];
+    credentials: number;
 }
 
 async function fetchAPIKeys(): Promise<APIKeyInfo[]> {
@@ -17,9 +38,18 @@ async function fetchAPIKeys(): Promise<APIKeyInfo[]> {
     }
 }
 
-async function createAPIKey(label: string): Promise<{ api_key: string; key_id: string } | null> {
+async function createAPIKey(label: string, scopes: string[], expiresInDays: number): Promise<{ api_key: string; key_id: string } | null> {
     try {
-        const { data } = await api.post<{ api_key: string; key_id: string }>('/api/token', { label });
+        const { data } = await api.post<{ api_key: string; key_id: string }>('/api/token', { label, scopes, expires_in_days: expiresInDays });
+        return data;
+    } catch {
+        return null;
+    }
+}
+
+async function rotateAPIKey(keyId: string): Promise<{ api_key: string; key_id: string; old_key_expires_at: string } | null> {
+    try {
+        const { data } = await api.post<{ api_key: string; key_id: string; old_key_expires_at: string }>('/api/token/rotate', {}, { params: { id: keyId } });
         return data;
     } catch {
         return null;
@@ -35,6 +65,30 @@ async function deleteAPIKey(keyId: string): Promise<boolean> {
     }
 }
 
+async function fetchConnections(): Promise<Connection[]> {
+    try {
+        const { data } = await api.get<Connection[]>('/api/connections');
+        return data ?? [];
+    } catch {
+        return [];
+    }
+}
+
+async function revokeConnection(clientId: string): Promise<boolean> {
+    try {
+        await api.delete('/api/connections', { params: { client_id: clientId } });
+        return true;
+    } catch {
+        return false;
+    }
+}
+
+function escapeHTML(s: string): string {
+    const div = document.createElement('div');
+    div.textContent = s;
+    return div.innerHTML;
+}
+
 function copyWithFeedback(btn: HTMLElement, text: string): void {
     void navigator.clipboard.writeText(text).then(() => {
         const orig = btn.textContent;
@@ -55,24 +109,51 @@ function renderKeyRow(key: APIKeyInfo): string {
         actionBtn = `<button class="btn btn-outline-warning btn-sm delete-key-btn" data-key-id="${key.key_id}" data-is-session="true" data-bs-toggle="tooltip" data-bs-title="This will log you out">Revoke session</button>`;
     } else {
         actionBtn = `<button class="btn btn-outline-danger btn-sm delete-key-btn" data-key-id="${key.key_id}">Revoke</button>`;
+        if (!key.rotated_to) {
+            actionBtn = `<button class="btn btn-outline-secondary btn-sm rotate-key-btn me-1" data-key-id="${key.key_id}">Rotate</button>` + actionBtn;
+        }
     }
+    if (key.rotated_to) {
+        badge += ' <span class="badge text-bg-warning">rotated</span>';
+    }
+    const scopes = key.scopes?.length ? `<div class="form-text">${key.scopes.map(escapeHTML).join(', ')}</div>` : '';
+    const lastUsed = key.last_used_at ? new Date(key.last_used_at).toLocaleString() : 'Never';
+    const expires = key.expires_at ? new Date(key.expires_at).toLocaleString() : 'Never';
     return `<tr data-key-id="${key.key_id}">
-        <td>${key.label || 'Untitled'}${badge}</td>
+        <td>${escapeHTML(key.label || 'Untitled')}${badge}${scopes}</td>
         <td class="text-body-secondary">${created}</td>
-        <td class="text-end">${actionBtn}</td>
+        <td class="text-body-secondary">${lastUsed}</td>
+        <td class="text-body-secondary">${expires}</td>
+        <td class="text-end text-nowrap">${actionBtn}</td>
+    </tr>`;
+}
+
+function renderConnectionRow(conn: Connection): string {
+    const connected = new Date(conn.connected_at).toLocaleDateString();
+    const lastUsed = conn.last_used_at ? new Date(conn.last_used_at).toLocaleString() : 'Never';
+    return `<tr>
+        <td>${escapeHTML(conn.client_name)}<div class="form-text">${conn.scopes.map(escapeHTML).join(', ')}</div></td>
+        <td class="text-body-secondary">${connected}</td>
+        <td class="text-body-secondary">${lastUsed}</td>
+        <td class="text-end"><button class="btn btn-outline-danger btn-sm disconnect-btn" data-client-id="${escapeHTML(conn.client_id)}" data-client-name="${escapeHTML(conn.client_name)}">Disconnect</button></td>
     </tr>`;
 }
 
 export async function renderKeys(container: HTMLElement): Promise<void> {
     container.innerHTML = '<div class="text-center py-4"><div class="spinner-border" role="status"></div></div>';
 
-    const keys = await fetchAPIKeys();
+    const [allKeys, connections] = await Promise.all([fetchAPIKeys(), fetchConnections()]);
+    // Keys issued to OAuth apps are listed under Connected Apps instead
+    const keys = allKeys.filter(k => !k.label.startsWith('OAuth: '));
     const endpoint = 'https://k24xsd279c.execute-api.us-east-1.amazonaws.com/mcp';
 
     keys.sort((a, b) => a.created_at.localeCompare(b.created_at));
     const keyRows = keys.length > 0
         ? keys.map(renderKeyRow).join('')
-        : '<tr><td colspan="3" class="text-body-secondary">No API keys yet.</td></tr>';
+        : '<tr><td colspan="5" class="text-body-secondary">No API keys yet.</td></tr>';
+    const connectionRows = connections.length > 0
+        ? connections.map(renderConnectionRow).join('')
+        : '<tr><td colspan="4" class="text-body-secondary">No apps connected.</td></tr>';
 
     container.innerHTML = `
         <h4>MCP Setup</h4>
@@ -88,7 +169,7 @@ export async function renderKeys(container: HTMLElement): Promise<void> {
             <label class="form-label fw-semibold">API Keys</label>
             <div class="table-responsive">
             <table class="table table-sm mb-2">
-                <thead><tr><th>Label</th><th>Created</th><th></th></tr></thead>
+                <thead><tr><th>Label</th><th>Created</th><th>Last used</th><th>Expires</th><th></th></tr></thead>
                 <tbody id="api-keys-tbody">${keyRows}</tbody>
             </table>
             </div>
@@ -100,8 +181,28 @@ export async function renderKeys(container: HTMLElement): Promise<void> {
             </div>
             <div class="input-group input-group-sm">
                 <input type="text" class="form-control" placeholder="Key label (e.g. Claude Code)" id="new-key-label" required>
+                <select class="form-select flex-grow-0 w-auto" id="new-key-access">
+                    <option value="full">Full access</option>
+                    <option value="log">Log entries</option>
+                    <option value="read">Read only</option>
+                </select>
+                <select class="form-select flex-grow-0 w-auto" id="new-key-expiry">
+                    <option value="0">Never expires</option>
+                    <option value="30">30 days</option>
+                    <option value="90">90 days</option>
+                    <option value="365">1 year</option>
+                </select>
                 <button class="btn btn-primary" type="button" id="create-key-btn">Create Key</button>
             </div>
+        </div>
+        <div class="mb-4">
+            <label class="form-label fw-semibold">Connected Apps</label>
+            <div class="table-responsive">
+            <table class="table table-sm mb-2">
+                <thead><tr><th>App</th><th>Connected</th><th>Last used</th><th></th></tr></thead>
+                <tbody>${connectionRows}</tbody>
+            </table>
+            </div>
         </div>`;
 
     bindButtons(() => renderKeys(container));
@@ -125,12 +226,14 @@ function bindButtons(refresh: () => Promise<void>): void {
             return;
         }
         input?.classList.remove('is-invalid');
-        const result = await createAPIKey(label);
+        const access = (document.getElementById('new-key-access') as HTMLSelectElement | null)?.value ?? 'full';
+        const expiry = Number((document.getElementById('new-key-expiry') as HTMLSelectElement | null)?.value ?? 0);
+        const result = await createAPIKey(label, accessLevels[access] ?? [], expiry);
         if (!result) {
             return;
         }
 
-        if (!localStorage.getItem('api_key')) {
+        if (!localStorage.getItem('api_key') && access === 'full') {
             localStorage.setItem('api_key', result.api_key);
         }
 
@@ -155,6 +258,43 @@ function bindButtons(refresh: () => Promise<void>): void {
         new bootstrap.Tooltip(el);
     });
 
+    document.querySelectorAll('.disconnect-btn').forEach(btn => {
+        btn.addEventListener('click', async () => {
+            const el = btn as HTMLElement;
+            const clientId = el.dataset.clientId;
+            if (!clientId) {
+                return;
+            }
+            if (!confirm(`Disconnect ${el.dataset.clientName ?? 'this app'}? It will lose access to your JustLog data.`)) {
+                return;
+            }
+            await revokeConnection(clientId);
+            await refresh();
+        });
+    });
+
+    document.querySelectorAll('.rotate-key-btn').forEach(btn => {
+        btn.addEventListener('click', async () => {
+            const keyId = (btn as HTMLElement).dataset.keyId;
+            if (!keyId || !confirm('Issue a replacement for this key? The old key keeps working for 24 hours.')) {
+                return;
+            }
+            const result = await rotateAPIKey(keyId);
+            if (!result) {
+                return;
+            }
+
+            await refresh();
+
+            const alertEl = document.getElementById('new-key-alert');
+            const value = document.getElementById('new-key-value');
+            if (alertEl && value) {
+                value.textContent = result.api_key;
+                alertEl.classList.remove('d-none');
+            }
+        });
+    });
+
     document.querySelectorAll('.delete-key-btn').forEach(btn => {
         btn.addEventListener('click', async () => {
             const el = btn as HTMLElement;